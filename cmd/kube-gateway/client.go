@@ -1,19 +1,27 @@
 package main
 
 import (
+	"context"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+
+	"github.com/yaacov/kube-gateway/pkg/proxy"
 )
 
 // Endpoint holds the API server authorization URL.
@@ -43,14 +51,13 @@ func getServerEndpoint(serverURL string, transport *http.Transport) (Endpoint, e
 
 // ClientTransport reads the CAFile and return init the http.Transport for the oauth2 server.
 func ClientTransport(CAFile string, skipVerifyTLS bool) (*http.Transport, error) {
-	var transport *http.Transport
+	opts := proxy.APITransportOptions{
+		TLSHandshakeTimeout: 30 * time.Second,
+	}
 
 	// Add or skip TLS
 	if skipVerifyTLS {
-		transport = &http.Transport{
-			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
-			TLSHandshakeTimeout: 30 * time.Second,
-		}
+		opts.InsecureSkipVerify = true
 	} else if CAFile != "" {
 		k8sCertPEM, err := ioutil.ReadFile(CAFile)
 		if err != nil {
@@ -61,17 +68,10 @@ func ClientTransport(CAFile string, skipVerifyTLS bool) (*http.Transport, error)
 			err := fmt.Errorf("no CA found for the API server in file %s", CAFile)
 			return nil, err
 		}
-		transport = &http.Transport{
-			TLSClientConfig:     &tls.Config{RootCAs: rootCAs},
-			TLSHandshakeTimeout: 30 * time.Second,
-		}
-	} else {
-		transport = &http.Transport{
-			TLSHandshakeTimeout: 30 * time.Second,
-		}
+		opts.RootCAs = rootCAs
 	}
 
-	return transport, nil
+	return proxy.NewAPITransport(opts), nil
 }
 
 // GetOAuthServerEndpoints gets authentication server endpoints
@@ -109,24 +109,89 @@ func GetOAuthServerEndpoints(oauthServerAuthURL *string, oauthServerTokenURL *st
 
 // ReadJWTKey reads the JWT secret key file, and create an RSA key
 func ReadJWTKey(filename string, alg string) ([]byte, *rsa.PublicKey) {
-	var err error
-	var jwtTokenKey []byte
-	var jwtTokenRSAKey *rsa.PublicKey
+	jwtTokenKey, jwtTokenRSAKey, err := readJWTKey(filename, alg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	if filename != "" {
-		jwtTokenKey, err = ioutil.ReadFile(filename)
+	return jwtTokenKey, jwtTokenRSAKey
+}
+
+// readJWTKey reads the JWT key file, an RSA public key when alg is an RSA algorithm,
+// or an HMAC secret key. Only one of the keys is returned, so an RSA public key is never
+// used as an HMAC secret.
+func readJWTKey(filename string, alg string) ([]byte, *rsa.PublicKey, error) {
+	if filename == "" {
+		return nil, nil, nil
+	}
+
+	key, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if strings.HasPrefix(strings.ToUpper(alg), "RS") {
+		rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(key)
 		if err != nil {
-			log.Fatal(err)
+			return nil, nil, err
 		}
-		if alg == "RS265" {
-			jwtTokenRSAKey, err = jwt.ParseRSAPublicKeyFromPEM(jwtTokenKey)
-			if err != nil {
-				log.Fatal(err)
-			}
+		return nil, rsaKey, nil
+	}
+
+	return key, nil, nil
+}
+
+// ReloadOnHangup reloads the server keys and policy when a SIGHUP signal is received,
+// until ctx is done. When configFile is set, the JWT keys, path rules and allowed groups
+// are read from it using ReloadConfig. Otherwise the JWT key file and the k8s bearer token
+// file are read again, and the path rules and allowed groups set by flags are kept. A failed
+// reload keeps the current configuration.
+func ReloadOnHangup(ctx context.Context, s *proxy.Server, configFile string, jwtTokenKeyFile string, alg string, bearerTokenFile string) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+		}
+
+		var err error
+		if configFile != "" {
+			err = s.ReloadConfig(configFile)
+		} else {
+			err = reloadKeys(s, jwtTokenKeyFile, alg, bearerTokenFile)
+		}
+		if err != nil {
+			log.Printf("fail to reload configuration: %+v", err)
 		}
 	}
+}
 
-	return jwtTokenKey, jwtTokenRSAKey
+// reloadKeys reads the JWT key file and the k8s bearer token file again, and reloads
+// them keeping the current policy.
+func reloadKeys(s *proxy.Server, jwtTokenKeyFile string, alg string, bearerTokenFile string) error {
+	jwtTokenKey, jwtTokenRSAKey, err := readJWTKey(jwtTokenKeyFile, alg)
+	if err != nil {
+		return err
+	}
+
+	bearerToken, err := ReadSABearerToken(bearerTokenFile)
+	if err != nil {
+		return err
+	}
+
+	return s.Reload(proxy.Reloadable{
+		BearerToken:          bearerToken,
+		JWTTokenKey:          jwtTokenKey,
+		JWTTokenRSAKey:       jwtTokenRSAKey,
+		JWKSURL:              s.JWKSURL,
+		PathRules:            s.PathRules,
+		PathRulesDefaultDeny: s.PathRulesDefaultDeny,
+		AllowedGroups:        s.AllowedGroups,
+	})
 }
 
 // ReadSABearerToken read the k8s service account access token file
@@ -142,3 +207,114 @@ func ReadSABearerToken(filename string) (string, error) {
 
 	return strings.TrimSpace(k8sBearerToken), nil
 }
+
+// ReadClientCert reads a client certificate and key pair, returns nil if no files are set
+func ReadClientCert(certFile string, keyFile string) (*tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read client certificate: %+v", err)
+	}
+
+	return &cert, nil
+}
+
+// ReadKeyFile reads a secret key file
+func ReadKeyFile(filename string) ([]byte, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	key, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strings.TrimSpace(string(key))), nil
+}
+
+// NewLogger creates a structured logger using the requested format
+func NewLogger(format string) *slog.Logger {
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, nil))
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stderr, nil))
+}
+
+// ParseRoutes parses a comma separated list of path=URL pairs into API server routes
+func ParseRoutes(routes string) (map[string]*proxy.Route, error) {
+	if routes == "" {
+		return nil, nil
+	}
+
+	parsed := map[string]*proxy.Route{}
+	for _, pair := range strings.Split(routes, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid API route %q, expected path=URL", pair)
+		}
+
+		path := parts[0]
+		if !strings.HasSuffix(path, "/") {
+			path = path + "/"
+		}
+		parsed[path] = &proxy.Route{APIServerURL: parts[1]}
+	}
+
+	return parsed, nil
+}
+
+// SplitList splits a comma separated list, an empty string is an empty list
+func SplitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	return strings.Split(list, ",")
+}
+
+// ParseSameSite parses a cookie SameSite attribute, an empty string is the default mode
+func ParseSameSite(sameSite string) (http.SameSite, error) {
+	switch strings.ToLower(sameSite) {
+	case "":
+		return 0, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid cookie SameSite %q, expected lax, strict or none", sameSite)
+	}
+}
+
+// ParseEnvFlags sets flags not given on the command line from environment variables,
+// the variable name is the prefix followed by the upper case flag name, with "-"
+// replaced by "_", e.g. KUBE_GATEWAY_API_SERVER for the api-server flag.
+func ParseEnvFlags(fs *flag.FlagSet, prefix string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+
+		name := prefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %+v", value, name, e)
+			}
+		}
+	})
+
+	return err
+}
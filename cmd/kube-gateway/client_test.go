@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/yaacov/kube-gateway/pkg/proxy"
+)
+
+func TestReadJWTKeyAlgorithm(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("fail to generate RSA key: %v", err)
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("fail to marshal RSA key: %v", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+	hmacKey := []byte("test-hmac-key")
+
+	dir := t.TempDir()
+	rsaKeyFile := filepath.Join(dir, "rsa.pem")
+	hmacKeyFile := filepath.Join(dir, "hmac.key")
+	if err := os.WriteFile(rsaKeyFile, publicKeyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hmacKeyFile, hmacKey, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{"sub": "user", "apiGroups": []interface{}{"*"}}
+	rs256, _ := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(hmacKey)
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(publicKeyPEM)
+
+	tests := []struct {
+		name     string
+		keyFile  string
+		alg      string
+		token    string
+		wantCode int
+	}{
+		{name: "RS256 token", keyFile: rsaKeyFile, alg: "RS265", token: rs256, wantCode: http.StatusOK},
+		{name: "HS256 token signed with the RSA public key", keyFile: rsaKeyFile, alg: "RS265", token: forged, wantCode: http.StatusForbidden},
+		{name: "HS256 token signed with the RSA public key, RS256 alg", keyFile: rsaKeyFile, alg: "RS256", token: forged, wantCode: http.StatusForbidden},
+		{name: "HS256 token", keyFile: hmacKeyFile, alg: "HS265", token: hs256, wantCode: http.StatusOK},
+		{name: "RS256 token with an HMAC key", keyFile: hmacKeyFile, alg: "HS265", token: rs256, wantCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, rsaKey, err := readJWTKey(tt.keyFile, tt.alg)
+			if err != nil {
+				t.Fatalf("readJWTKey() error = %v", err)
+			}
+
+			s, err := proxy.NewServer(
+				proxy.WithAPIServer("https://kubernetes.default.svc", nil),
+				proxy.WithBearerToken("test-bearer-token"),
+				proxy.WithJWTKey(key, rsaKey),
+			)
+			if err != nil {
+				t.Fatalf("NewServer() error = %v", err)
+			}
+
+			handler := s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidateRSAPublicKeyAsHMACKey(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("fail to generate RSA key: %v", err)
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("fail to marshal RSA key: %v", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	s, err := proxy.NewServer(
+		proxy.WithAPIServer("https://kubernetes.default.svc", nil),
+		proxy.WithBearerToken("test-bearer-token"),
+		proxy.WithJWTKey(publicKeyPEM, &privateKey.PublicKey),
+	)
+	if err == nil {
+		t.Fatalf("NewServer() error = nil, want an error for an RSA public key used as HMAC key")
+	}
+
+	s, err = proxy.NewServer(
+		proxy.WithAPIServer("https://kubernetes.default.svc", nil),
+		proxy.WithBearerToken("test-bearer-token"),
+		proxy.WithJWTKey(nil, &privateKey.PublicKey),
+	)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if err := s.Reload(proxy.Reloadable{BearerToken: "test-bearer-token", JWTTokenKey: publicKeyPEM, JWTTokenRSAKey: &privateKey.PublicKey}); err == nil {
+		t.Fatalf("Reload() error = nil, want an error for an RSA public key used as HMAC key")
+	}
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"golang.org/x/oauth2"
 
@@ -17,8 +22,17 @@ import (
 const (
 	authLoginEndpoint         = "/auth/login"
 	authLoginCallbackEndpoint = "/auth/callback"
+	authLogoutEndpoint        = "/auth/logout"
+	authUserInfoEndpoint      = "/auth/userinfo"
+	authRevokeEndpoint        = "/auth/revoke"
 	authSetTokenEndpoint      = "/auth/token"
 	authGetTokenEndpoint      = "/auth/gettoken"
+	metricsEndpoint           = "/metrics"
+	healthEndpoint            = "/healthz"
+	readyEndpoint             = "/readyz"
+
+	// envPrefix is the prefix of environment variables setting flags.
+	envPrefix = "KUBE_GATEWAY_"
 )
 
 func main() {
@@ -28,10 +42,53 @@ func main() {
 	basePath := flag.String("base-path", "/", "server endpoint for static web assets.")
 	apiServer := flag.String("api-server", "", "backend API server URL.")
 	apiPath := flag.String("api-path", "/k8s/", "server endpoint for API calls.")
-
-	listen := flag.String("listen", "https://0.0.0.0:8080", "")
-	baseAddress := flag.String("base-address", "https://localhost:8080", "This server base address.")
-
+	upstreamHost := flag.String("upstream-host", "", "If set, the Host header and TLS server name used for k8s API server requests.")
+	preserveAPIPath := flag.Bool("preserve-api-path", false, "If true forward the full request path to the k8s API server, otherwise the api-path prefix is removed.")
+	apiRoutes := flag.String("api-routes", "", "Additional API servers, comma separated list of path=URL pairs, e.g. \"/cluster-a/=https://a:6443\".")
+
+	listen := flag.String("listen", "https://0.0.0.0:8080", "Listen address URL, the scheme (http or https) selects if TLS is used.")
+	shutdownGracePeriod := flag.Duration("shutdown-grace-period", 30*time.Second, "Time in-flight requests have to complete on shutdown.")
+	logFormat := flag.String("log-format", "text", "Request log format (supported formats text, json).")
+	auditLog := flag.Bool("audit-log", false, "If true log every authorization decision as an audit record.")
+	accessLog := flag.String("access-log", "", "If set, write an access log to stdout (supported formats combined, json).")
+	accessLogRedactUser := flag.Bool("access-log-redact-user", false, "If true log a hash of the token subject in the access log instead of the subject.")
+	baseAddress := flag.String("base-address", "https://localhost:8080", "This server base address, if empty the OAuth2 redirect address is derived from each login request.")
+	corsAllowedOrigins := flag.String("cors-allowed-origins", "", "If set, comma separated list of origins allowed to make cross origin requests, \"*\" allows any origin.")
+	corsAllowCredentials := flag.Bool("cors-allow-credentials", false, "If true allow browsers to send the session cookie with cross origin requests, can not be used with the \"*\" origin.")
+	rateLimit := flag.Float64("rate-limit", 0, "If set, maximum requests per second allowed for each client.")
+	rateLimitBurst := flag.Int("rate-limit-burst", 0, "Maximum burst of requests allowed for each client, defaults to the rate limit.")
+	publicPaths := flag.String("public-paths", "/login.html", "Comma separated list of paths exempt from authentication, paths ending with \"/\" match as prefix.")
+	allowedWebSocketOrigins := flag.String("allowed-websocket-origins", "", "Comma separated list of origins allowed to send WebSocket requests, \"*\" allows any origin, by default only the same origin is allowed.")
+	allowedRedirectHosts := flag.String("allowed-redirect-hosts", "", "Comma separated list of hosts the token endpoint may redirect to, by default only local paths are allowed.")
+	allowedRequestHeaders := flag.String("allowed-request-headers", "", "Comma separated list of request headers forwarded to the k8s API server, if empty all headers are forwarded, e.g. \"Accept,Content-Type\".")
+	skipValidationPaths := flag.String("skip-validation-paths", "", "Comma separated list of API paths, relative to the api-path, served using the k8s bearer token without JWT validation, e.g. \"/version\".")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "If true expect connections to start with a PROXY protocol header, e.g. behind a TCP load balancer.")
+	trustedProxies := flag.String("trusted-proxies", "", "Comma separated list of trusted proxy CIDRs, allowed to set X-Forwarded-For and X-Forwarded-Proto headers.")
+	tokenHeaders := flag.String("token-headers", "Authorization", "Comma separated list of HTTP headers checked for a request token, headers other than Authorization hold a raw token.")
+	sessionCookieName := flag.String("session-cookie-name", "ocgate-session-token", "Name of the session cookie.")
+	cookieSecure := flag.Bool("cookie-secure", false, "If true always mark cookies as Secure, otherwise cookies are Secure on https requests.")
+	cookieSameSite := flag.String("cookie-samesite", "lax", "SameSite attribute of the session cookie (supported values lax, strict, none).")
+	cookieDomain := flag.String("cookie-domain", "", "If set, the Domain attribute of the cookies.")
+	sessionStore := flag.String("session-store", "cookie", "Where session tokens are kept (supported values cookie, memory), the memory store keeps only a session id in the cookie.")
+	loginStateStore := flag.String("login-state-store", "cookie", "Where the OAuth2 state, PKCE code verifier and nonce are kept during login (supported values cookie, memory), the memory store keeps only a login state id in the cookie.")
+	sessionMaxAge := flag.Duration("session-max-age", 0, "Session cookie lifetime when the token expiry is not known, zero means the cookie expires when the browser closes.")
+	bindSessionToClient := flag.Bool("bind-session-to-client", false, "If true bind sessions to the client User-Agent recorded at login, a session used by another client requires a new login.")
+	bindSessionToClientIP := flag.Bool("bind-session-to-client-ip", false, "If true also bind sessions to the client IP network (/24 for IPv4, /64 for IPv6), requires bind-session-to-client.")
+	adminTokenFile := flag.String("admin-token-file", "", "If set, enable the token revocation endpoint, requests must use the token in this file as bearer token.")
+	cookieSigningKeyFile := flag.String("cookie-signing-key-file", "", "If set, sign the login cookies using the key in this file, required when running more than one replica, by default a random key is used.")
+	cookieEncryptionKeyFile := flag.String("cookie-encryption-key-file", "", "If set, encrypt the session cookie using the key in this file.")
+
+	upstreamTimeout := flag.Duration("upstream-timeout", 30*time.Second, "Time to wait for the k8s API server response headers.")
+	disableHTTP2 := flag.Bool("disable-http2", false, "If true, use HTTP/1.1 for k8s API server requests instead of negotiating HTTP/2.")
+	circuitBreakerThreshold := flag.Int("circuit-breaker-threshold", 0, "If set, number of consecutive upstream failures that open the circuit breaker.")
+	circuitBreakerCooldown := flag.Duration("circuit-breaker-cooldown", 30*time.Second, "Time the circuit breaker stays open before testing upstream recovery.")
+	requestTimeout := flag.Duration("request-timeout", 0, "If set, the overall time a proxied request may take, watch, log, exec and upgrade requests are not limited.")
+	maxRequestBodyBytes := flag.Int64("max-request-body-bytes", 0, "If set, limit the size of request bodies, watch and upgrade requests are not limited.")
+	secureResponseHeaders := flag.Bool("secure-response-headers", false, "If true add nosniff and no-store headers to proxied responses, and remove k8s API server internal headers.")
+	enableCompression := flag.Bool("enable-compression", false, "If true compress proxied responses using gzip or deflate when the client accepts it.")
+	maxConcurrentRequests := flag.Int("max-concurrent-requests", 0, "If set, maximum number of in-flight proxied requests, watch and exec streams are not counted.")
+	concurrencyQueueTimeout := flag.Duration("concurrency-queue-timeout", 0, "Time a request waits for a slot when max-concurrent-requests is reached, zero means fail fast with 503.")
+	maxRetries := flag.Int("max-retries", 0, "Number of times idempotent requests are retried on upstream connection errors and 503 responses.")
 	caFile := flag.String("ca-file", "", "PEM File containing trusted certificates for k8s API server. If not present, the system's Root CAs will be used.")
 	skipVerifyTLS := flag.Bool("skip-verify-tls", false, "When true, skip verification of certs presented by k8s API server.")
 
@@ -41,16 +98,42 @@ func main() {
 	oauthServerDisable := flag.Bool("oauth-server-disable", false, "If true will disable interactive authentication using OAuth2 issuer.")
 	oauthServerTokenURL := flag.String("oauth-server-token-url", "", "OAuth2 issuer token endpoint URL.")
 	oauthServerAuthURL := flag.String("oauth-server-auth-url", "", "OAuth2 issuer authentication endpoint URL.")
+	oauthVerifyIDToken := flag.Bool("oauth-verify-id-token", false, "If true require and verify the OpenID Connect id_token on login, the token is verified using the jwks-url keys.")
+	allowedGroups := flag.String("allowed-groups", "", "Comma separated list of groups, if set tokens must have one of the groups in their groups claim.")
+	oauthScopes := flag.String("oauth-scopes", "", "Comma separated list of scopes requested on login in addition to the default scopes, e.g. \"openid,profile,email,groups\".")
+	oauthUseNonce := flag.Bool("oauth-use-nonce", false, "If true add an OpenID Connect nonce to the login request, and verify it in the returned id_token.")
+	oauthServerUserInfoURL := flag.String("oauth-server-userinfo-url", "", "OpenID Connect userinfo endpoint URL, if set used by the userinfo endpoint, by default user info is read from the token claims.")
+	oauthServerRevocationURL := flag.String("oauth-server-revocation-url", "", "OAuth2 issuer token revocation endpoint URL, if set tokens are revoked on logout.")
 	oauthClientID := flag.String("oauth-client-id", "kube-gateway-client", "OAuth2 client ID defined in a OAuthClient k8s object.")
 	oauthClientSecret := flag.String("oauth-client-secret", "my-secret", "OAuth2 client secret defined in a OAuthClient k8s object.")
+	oauthExchangeTimeout := flag.Duration("oauth-exchange-timeout", 10*time.Second, "Timeout for requests to the OAuth2 issuer token and revocation endpoints.")
+	oauthUsePKCE := flag.Bool("oauth-use-pkce", false, "If true use PKCE (S256 code challenge) in the OAuth2 authorization code flow.")
+	oauthAccessTypeOffline := flag.Bool("oauth-access-type-offline", false, "If true request offline access on login, needed by some OAuth2 issuers to return refresh tokens.")
+	oauthPrompt := flag.String("oauth-prompt", "", "OAuth2 prompt parameter sent on login, e.g. \"login\" or \"select_account\", defaults to \"consent\".")
 
 	jwtTokenKeyFile := flag.String("jwt-token-key-file", "", "validate JWT token received from OAuth2 using the key in this file.")
 	jwtTokenKeyAlg := flag.String("jwt-token-key-alg", "RS265", "JWT token key signing algorithm (supported algorithms HS265, RS265).")
-	k8sBearerTokenfile := flag.String("k8s-bearer-token-file", "", "Replace valid JWT tokens with this token for k8s API calls.")
+	jwksURL := flag.String("jwks-url", "", "If set, validate JWT tokens using the public keys from this JSON Web Key Set endpoint.")
+	jwtAudience := flag.String("jwt-audience", "", "If set, JWT tokens must include this value in their aud claim.")
+	jwtIssuer := flag.String("jwt-issuer", "", "If set, JWT tokens must have this value as their iss claim.")
+	jwtClockSkew := flag.Duration("jwt-clock-skew", 0, "Leeway allowed when validating JWT token exp and nbf claims.")
+	k8sClientCertFile := flag.String("k8s-client-cert-file", "", "If set, authenticate to the k8s API using this client certificate file (requires k8s-client-key-file).")
+	k8sClientKeyFile := flag.String("k8s-client-key-file", "", "Client certificate key file used to authenticate to the k8s API.")
+	k8sBearerTokenfile := flag.String("k8s-bearer-token-file", "", "Replace valid JWT tokens with the token in this file for k8s API calls, the file is read again when the token rotates.")
+	readOnly := flag.Bool("read-only", false, "If true reject mutating requests (e.g. POST, PUT, PATCH and DELETE) to the k8s API.")
+	validationCacheTTL := flag.Duration("validation-cache-ttl", 0, "If set, time a validated token is cached, repeated requests with the same token skip validation.")
+	tokenReview := flag.Bool("token-review", false, "If true authenticate tokens using a k8s TokenReview instead of validating JWT tokens, requires impersonate-users.")
+	impersonateUsers := flag.Bool("impersonate-users", false, "If true impersonate the JWT token subject and groups when using the k8s bearer token.")
+	configFile := flag.String("config-file", "", "If set, read the JWT keys, allowed groups and path rules from this YAML or JSON config file, the file is read again on SIGHUP.")
 	k8sBearerTokenPassthrough := flag.String("k8s-bearer-token-passthrough", "false", "If \"true\" use token received from OAuth2 server as the token for k8s API calls.")
 
 	flag.Parse()
 
+	// Read flags not set on the command line from the environment
+	if err := ParseEnvFlags(flag.CommandLine, envPrefix); err != nil {
+		log.Fatal(err)
+	}
+
 	// Print usage message
 	if *help {
 		flag.PrintDefaults()
@@ -72,11 +155,24 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Read the k8s client certificate
+	clientCert, err := ReadClientCert(*k8sClientCertFile, *k8sClientKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Parse pass through string into boolean,
 	// Note: making boolean input a string helps automation,
 	// it's easier to automate "true"/"false" then "-k8s-bearer-token-passthrough"/""
-	if *k8sBearerTokenPassthrough != "false" || k8sBearerToken == "" {
+	// Without a bearer token, a client certificate or passthrough the server fails validation
+	passthrough := *k8sBearerTokenPassthrough != "false"
+	k8sBearerTokenFile := *k8sBearerTokenfile
+	if passthrough {
+		k8sBearerToken = ""
+		k8sBearerTokenFile = ""
 		log.Print("pass through bearer token from oauth issuer to k8s API calls")
+	} else if k8sBearerToken == "" {
+		log.Print("use client certificate for k8s API calls")
 	} else {
 		log.Print("use user defined bearer token for k8s API calls")
 	}
@@ -91,6 +187,30 @@ func main() {
 		log.Printf("read CAFile [%s]", *caFile)
 	}
 
+	// Read cookie signing key file
+	cookieSigningKey, err := ReadKeyFile(*cookieSigningKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Read cookie encryption key file
+	cookieEncryptionKey, err := ReadKeyFile(*cookieEncryptionKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Read admin token
+	adminToken, err := ReadKeyFile(*adminTokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Parse cookie SameSite attribute
+	sameSite, err := ParseSameSite(*cookieSameSite)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Read JWT secret file
 	jwtTokenKey, jwtTokenRSAKey := ReadJWTKey(*jwtTokenKeyFile, *jwtTokenKeyAlg)
 	log.Printf("read JWT key file [%s]", *jwtTokenKeyFile)
@@ -118,24 +238,158 @@ func main() {
 		},
 		RedirectURL: redirectURL,
 	}
+	if *oauthVerifyIDToken || *oauthUseNonce {
+		oauthConf.Scopes = append(oauthConf.Scopes, "openid")
+	}
+
+	// Parse additional API server routes
+	routes, err := ParseRoutes(*apiRoutes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Set cross origin requests config
+	var cors *proxy.CORSConfig
+	if *corsAllowedOrigins != "" {
+		cors = &proxy.CORSConfig{
+			AllowedOrigins:   strings.Split(*corsAllowedOrigins, ","),
+			AllowCredentials: *corsAllowCredentials,
+		}
+	}
+
+	// Set rate limit config
+	var rateLimitConfig *proxy.RateLimitConfig
+	if *rateLimit > 0 {
+		rateLimitConfig = &proxy.RateLimitConfig{
+			RequestsPerSecond: *rateLimit,
+			Burst:             *rateLimitBurst,
+		}
+	}
+
+	// Init metrics
+	metrics, err := proxy.NewMetrics(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Init server
 	s := &proxy.Server{
-		APIPath:      *apiPath,
-		APIServerURL: *apiServer,
-		APITransport: transport,
-		Auth2Config:  oauthConf,
+		APIPath:         *apiPath,
+		PreserveAPIPath: *preserveAPIPath,
+		UpstreamHost:    *upstreamHost,
+		Routes:          routes,
+		CORS:            cors,
+		RateLimit:       rateLimitConfig,
+
+		TrustedProxies: SplitList(*trustedProxies),
+		PublicPaths:    SplitList(*publicPaths),
+		APIServerURL:   *apiServer,
+		APITransport:   transport,
+		Auth2Config:    oauthConf,
+
+		AllowInsecureUpstream: *skipVerifyTLS,
+		SkipValidationPaths:   SplitList(*skipValidationPaths),
+		AllowedRequestHeaders: SplitList(*allowedRequestHeaders),
+
+		TokenHeaders:        SplitList(*tokenHeaders),
+		SessionCookieName:   *sessionCookieName,
+		CookieSecure:        *cookieSecure,
+		CookieSameSite:      sameSite,
+		CookieDomain:        *cookieDomain,
+		AdminToken:          strings.TrimSpace(string(adminToken)),
+		SessionMaxAge:       *sessionMaxAge,
+		CookieSigningKey:    cookieSigningKey,
+		CookieEncryptionKey: cookieEncryptionKey,
+
+		BindSessionToClient:   *bindSessionToClient,
+		BindSessionToClientIP: *bindSessionToClientIP,
 
 		BaseAddress:    *baseAddress,
 		IssuerEndpoint: endpoint.Issuer,
 		LoginEndpoint:  authLoginEndpoint,
 
+		AllowedRedirectHosts:    SplitList(*allowedRedirectHosts),
+		AllowedWebSocketOrigins: SplitList(*allowedWebSocketOrigins),
+
 		BearerToken:            k8sBearerToken,
-		BearerTokenPassthrough: *k8sBearerTokenPassthrough != "false",
+		BearerTokenFile:        k8sBearerTokenFile,
+		BearerTokenPassthrough: passthrough,
+		ImpersonateUsers:       *impersonateUsers,
+		AllowedGroups:          SplitList(*allowedGroups),
+		TokenReviewValidation:  *tokenReview,
+		ValidationCacheTTL:     *validationCacheTTL,
+		ReadOnly:               *readOnly,
+		ClientCert:             clientCert,
 		JWTTokenKey:            jwtTokenKey,
 		JWTTokenRSAKey:         jwtTokenRSAKey,
+		JWKSURL:                *jwksURL,
+		ExpectedAudience:       *jwtAudience,
+		ExpectedIssuer:         *jwtIssuer,
+		ClockSkew:              *jwtClockSkew,
 
 		InteractiveAuth: !*oauthServerDisable,
+		UsePKCE:         *oauthUsePKCE,
+		VerifyIDToken:   *oauthVerifyIDToken,
+		UseNonce:        *oauthUseNonce,
+		Scopes:          SplitList(*oauthScopes),
+
+		AccessTypeOffline: *oauthAccessTypeOffline,
+		Prompt:            *oauthPrompt,
+
+		RevocationEndpoint: *oauthServerRevocationURL,
+		UserInfoEndpoint:   *oauthServerUserInfoURL,
+
+		UpstreamTimeout:      *upstreamTimeout,
+		DisableHTTP2:         *disableHTTP2,
+		OAuthExchangeTimeout: *oauthExchangeTimeout,
+		MaxRetries:           *maxRetries,
+		EnableCompression:    *enableCompression,
+		MaxRequestBodyBytes:  *maxRequestBodyBytes,
+		RequestTimeout:       *requestTimeout,
+
+		CircuitBreakerThreshold: *circuitBreakerThreshold,
+		CircuitBreakerCooldown:  *circuitBreakerCooldown,
+		MaxConcurrentRequests:   *maxConcurrentRequests,
+		ConcurrencyQueueTimeout: *concurrencyQueueTimeout,
+
+		ShutdownGracePeriod: *shutdownGracePeriod,
+		ProxyProtocol:       *proxyProtocol,
+
+		Metrics: metrics,
+		Logger:  NewLogger(*logFormat),
+	}
+	if *secureResponseHeaders {
+		s.ResponseHeaderModifier = proxy.SecureResponseHeaders
+	}
+	if *sessionStore == "memory" {
+		s.SessionStore = proxy.NewMemorySessionStore()
+	} else if *sessionStore != "cookie" {
+		log.Fatalf("unsupported session store (%s)", *sessionStore)
+	}
+	if *loginStateStore == "memory" {
+		s.LoginStateStore = proxy.NewMemoryLoginStateStore()
+	} else if *loginStateStore != "cookie" {
+		log.Fatalf("unsupported login state store (%s)", *loginStateStore)
+	}
+	if *accessLog != "" {
+		s.AccessLog = os.Stdout
+		s.AccessLogFormat = *accessLog
+		s.AccessLogRedactUser = *accessLogRedactUser
+	}
+	if *auditLog {
+		s.AuditLogger = proxy.SlogAuditLogger{Logger: s.Logger}
+	}
+
+	// Read keys and policy from the config file
+	if *configFile != "" {
+		if err := s.ApplyConfig(*configFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Check server configuration, including the config file keys and policy
+	if err := s.Validate(); err != nil {
+		log.Fatal(err)
 	}
 
 	// Register oauth2 endpoints
@@ -145,9 +399,25 @@ func main() {
 	}
 	// Register manual auth endpoint
 	http.HandleFunc(authSetTokenEndpoint, s.Token)
+	http.HandleFunc(authLogoutEndpoint, s.Logout)
+	http.HandleFunc(authUserInfoEndpoint, s.UserInfo)
+
+	// Register admin endpoints
+	if s.AdminToken != "" {
+		http.Handle(authRevokeEndpoint, s.RevokeHandler())
+	}
+
+	// Register metrics and probe endpoints
+	http.Handle(metricsEndpoint, s.MetricsHandler())
+	http.Handle(healthEndpoint, s.HealthHandler())
+	http.Handle(readyEndpoint, s.ReadyHandler())
 
 	// Register proxy service
-	http.Handle(s.APIPath, s.AuthMiddleware(s.APIProxy()))
+	handler := s.Handler()
+	http.Handle(s.APIPath, handler)
+	for prefix := range s.Routes {
+		http.Handle(prefix, handler)
+	}
 
 	// Register static file server
 	fs := http.FileServer(http.Dir(*publicDir))
@@ -182,11 +452,18 @@ func main() {
 	log.Printf("Cert file: [%s] Key file: [%s]\n", *certFile, *keyFile)
 	log.Print("-------------------------------------")
 
+	// Stop gracefully on interrupt and terminate signals
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reload keys, tokens and policy on hangup signal
+	go ReloadOnHangup(ctx, s, *configFile, *jwtTokenKeyFile, *jwtTokenKeyAlg, k8sBearerTokenFile)
+
 	switch u.Scheme {
 	case "http":
-		err = http.ListenAndServe(u.Host, nil)
+		err = s.Run(ctx, u.Host)
 	case "https":
-		err = http.ListenAndServeTLS(u.Host, *certFile, *keyFile, nil)
+		err = s.RunTLS(ctx, u.Host, *certFile, *keyFile)
 	default:
 		err = fmt.Errorf("Unknown url schema %s", u.Scheme)
 	}
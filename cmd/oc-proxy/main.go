@@ -0,0 +1,321 @@
+// Command oc-proxy runs a proxy Server configured using command line flags, flags not
+// given on the command line are read from OC_PROXY_ environment variables.
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+
+	"github.com/yaacov/kube-gateway/pkg/proxy"
+)
+
+const (
+	loginEndpoint    = "/auth/login"
+	callbackEndpoint = "/auth/callback"
+	tokenEndpoint    = "/auth/token"
+	logoutEndpoint   = "/auth/logout"
+	healthEndpoint   = "/healthz"
+	readyEndpoint    = "/readyz"
+
+	// envPrefix is the prefix of environment variables setting flags.
+	envPrefix = "OC_PROXY_"
+)
+
+// config holds the command line flags.
+type config struct {
+	listen   string
+	certFile string
+	keyFile  string
+
+	apiServer     string
+	apiPath       string
+	caFile        string
+	skipVerifyTLS bool
+
+	bearerTokenFile        string
+	bearerTokenPassthrough bool
+	impersonateUsers       bool
+
+	jwtTokenKeyFile string
+	jwtTokenKeyAlg  string
+	jwksURL         string
+
+	baseAddress       string
+	oauthDisable      bool
+	oauthIssuerURL    string
+	oauthAuthURL      string
+	oauthTokenURL     string
+	oauthClientID     string
+	oauthClientSecret string
+	oauthScopes       string
+	oauthUsePKCE      bool
+}
+
+// parseFlags parses the command line arguments, and the environment variables of flags
+// not given on the command line.
+func parseFlags(args []string) (*config, error) {
+	c := &config{}
+	fs := flag.NewFlagSet("oc-proxy", flag.ContinueOnError)
+
+	fs.StringVar(&c.listen, "listen", "https://0.0.0.0:8080", "Listen address URL, the scheme (http or https) selects if TLS is used.")
+	fs.StringVar(&c.certFile, "cert-file", "", "PEM File containing certificates, required when listening on https.")
+	fs.StringVar(&c.keyFile, "key-file", "", "PEM File containing certificate key, required when listening on https.")
+
+	fs.StringVar(&c.apiServer, "api-server", "", "k8s API server URL.")
+	fs.StringVar(&c.apiPath, "api-path", "/k8s/", "Server endpoint for API calls.")
+	fs.StringVar(&c.caFile, "ca-file", "", "PEM File containing trusted certificates for the k8s API server, by default the system's root CAs are used.")
+	fs.BoolVar(&c.skipVerifyTLS, "skip-verify-tls", false, "If true, skip verification of the k8s API server certificate.")
+
+	fs.StringVar(&c.bearerTokenFile, "k8s-bearer-token-file", "", "Replace valid JWT tokens with the token in this file for k8s API calls, the file is read again when the token rotates.")
+	fs.BoolVar(&c.bearerTokenPassthrough, "k8s-bearer-token-passthrough", false, "If true use the request token for k8s API calls.")
+	fs.BoolVar(&c.impersonateUsers, "impersonate-users", false, "If true impersonate the JWT token subject and groups when using the k8s bearer token.")
+
+	fs.StringVar(&c.jwtTokenKeyFile, "jwt-token-key-file", "", "Validate JWT tokens using the key in this file.")
+	fs.StringVar(&c.jwtTokenKeyAlg, "jwt-token-key-alg", "RS256", "JWT token key signing algorithm (supported algorithms HS256, RS256).")
+	fs.StringVar(&c.jwksURL, "jwks-url", "", "If set, validate JWT tokens using the public keys from this JSON Web Key Set endpoint.")
+
+	fs.StringVar(&c.baseAddress, "base-address", "https://localhost:8080", "This server base address, used for the OAuth2 redirect URL.")
+	fs.BoolVar(&c.oauthDisable, "oauth-disable", false, "If true disable interactive authentication using an OAuth2 issuer.")
+	fs.StringVar(&c.oauthIssuerURL, "oauth-issuer-url", "", "OpenID Connect issuer URL, the OAuth2 endpoints are read from its discovery document.")
+	fs.StringVar(&c.oauthAuthURL, "oauth-auth-url", "", "OAuth2 issuer authorization endpoint URL.")
+	fs.StringVar(&c.oauthTokenURL, "oauth-token-url", "", "OAuth2 issuer token endpoint URL.")
+	fs.StringVar(&c.oauthClientID, "oauth-client-id", "", "OAuth2 client ID.")
+	fs.StringVar(&c.oauthClientSecret, "oauth-client-secret", "", "OAuth2 client secret.")
+	fs.StringVar(&c.oauthScopes, "oauth-scopes", "", "Comma separated list of scopes requested on login.")
+	fs.BoolVar(&c.oauthUsePKCE, "oauth-use-pkce", false, "If true use PKCE (S256 code challenge) in the OAuth2 authorization code flow.")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if err := parseEnvFlags(fs, envPrefix); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// parseEnvFlags sets flags not given on the command line from environment variables,
+// the variable name is the prefix followed by the upper case flag name, with "-"
+// replaced by "_", e.g. OC_PROXY_API_SERVER for the api-server flag.
+func parseEnvFlags(fs *flag.FlagSet, prefix string) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+
+		name := prefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value %q for %s: %+v", value, name, e)
+			}
+		}
+	})
+
+	return err
+}
+
+// newServer creates the proxy Server described by the flags, the configuration is
+// validated by proxy.NewServer.
+func newServer(ctx context.Context, c *config) (*proxy.Server, error) {
+	transport, err := apiTransport(c.caFile, c.skipVerifyTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []proxy.Option{
+		proxy.WithAPIServer(c.apiServer, transport),
+		proxy.WithAPIPath(c.apiPath),
+		func(s *proxy.Server) error {
+			s.AllowInsecureUpstream = c.skipVerifyTLS
+			s.BearerTokenFile = c.bearerTokenFile
+			s.ImpersonateUsers = c.impersonateUsers
+			s.UsePKCE = c.oauthUsePKCE
+			if c.jwksURL != "" {
+				s.JWKSURL = c.jwksURL
+			}
+			return nil
+		},
+	}
+
+	if c.bearerTokenPassthrough {
+		opts = append(opts, proxy.WithBearerTokenPassthrough())
+	}
+
+	key, rsaKey, err := readJWTKey(c.jwtTokenKeyFile, c.jwtTokenKeyAlg)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read JWT key file: %+v", err)
+	}
+	if len(key) > 0 || rsaKey != nil {
+		opts = append(opts, proxy.WithJWTKey(key, rsaKey))
+	}
+
+	if c.oauthDisable {
+		return proxy.NewServer(opts...)
+	}
+
+	// Interactive authentication
+	conf := &oauth2.Config{
+		ClientID:     c.oauthClientID,
+		ClientSecret: c.oauthClientSecret,
+		Endpoint:     oauth2.Endpoint{AuthURL: c.oauthAuthURL, TokenURL: c.oauthTokenURL},
+		RedirectURL:  strings.TrimSuffix(c.baseAddress, "/") + callbackEndpoint,
+	}
+	if c.oauthScopes != "" {
+		conf.Scopes = strings.Split(c.oauthScopes, ",")
+	}
+	opts = append(opts, proxy.WithInteractiveAuth(loginEndpoint))
+
+	if c.oauthIssuerURL != "" {
+		// Discovered endpoints are replaced by the endpoints given as flags
+		opts = append(opts, func(s *proxy.Server) error {
+			s.Auth2Config.RedirectURL = conf.RedirectURL
+			s.Auth2Config.Scopes = append(s.Auth2Config.Scopes, conf.Scopes...)
+			if conf.Endpoint.AuthURL != "" {
+				s.Auth2Config.Endpoint.AuthURL = conf.Endpoint.AuthURL
+			}
+			if conf.Endpoint.TokenURL != "" {
+				s.Auth2Config.Endpoint.TokenURL = conf.Endpoint.TokenURL
+			}
+			return nil
+		})
+		return proxy.NewServerFromOIDCDiscovery(ctx, c.oauthIssuerURL, c.oauthClientID, c.oauthClientSecret, opts...)
+	}
+
+	if c.oauthAuthURL == "" || c.oauthTokenURL == "" {
+		return nil, fmt.Errorf("interactive authentication requires an OAuth2 issuer URL, or authorization and token endpoint URLs")
+	}
+	opts = append(opts, proxy.WithOAuth(conf, ""))
+
+	return proxy.NewServer(opts...)
+}
+
+// apiTransport returns the transport used to reach the k8s API server.
+func apiTransport(caFile string, skipVerifyTLS bool) (*http.Transport, error) {
+	opts := proxy.APITransportOptions{InsecureSkipVerify: skipVerifyTLS}
+
+	if caFile != "" && !skipVerifyTLS {
+		caPEM, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no CA found for the API server in file %s", caFile)
+		}
+		opts.RootCAs = rootCAs
+	}
+
+	return proxy.NewAPITransport(opts), nil
+}
+
+// readJWTKey reads the JWT key file, an RSA public key when alg is an RSA algorithm,
+// or an HMAC secret key.
+func readJWTKey(filename string, alg string) ([]byte, *rsa.PublicKey, error) {
+	if filename == "" {
+		return nil, nil, nil
+	}
+
+	key, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if strings.HasPrefix(strings.ToUpper(alg), "RS") {
+		rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, rsaKey, nil
+	}
+
+	return key, nil, nil
+}
+
+// newServeMux registers the proxy Server endpoints.
+func newServeMux(s *proxy.Server) *http.ServeMux {
+	mux := http.NewServeMux()
+	if s.InteractiveAuth {
+		mux.HandleFunc(loginEndpoint, s.Login)
+		mux.HandleFunc(callbackEndpoint, s.Callback)
+	}
+	mux.HandleFunc(tokenEndpoint, s.Token)
+	mux.HandleFunc(logoutEndpoint, s.Logout)
+	mux.Handle(healthEndpoint, s.HealthHandler())
+	mux.Handle(readyEndpoint, s.ReadyHandler())
+
+	handler := s.Handler()
+	mux.Handle(s.APIPath, handler)
+	for prefix := range s.Routes {
+		mux.Handle(prefix, handler)
+	}
+
+	return mux
+}
+
+// run serves the proxy Server on the listen address until ctx is done.
+func run(ctx context.Context, s *proxy.Server, c *config) error {
+	u, err := url.Parse(c.listen)
+	if err != nil {
+		return fmt.Errorf("invalid listen address: %+v", err)
+	}
+
+	switch u.Scheme {
+	case "http":
+		return s.Run(ctx, u.Host)
+	case "https":
+		if c.certFile == "" || c.keyFile == "" {
+			return fmt.Errorf("listening on https requires a cert file and a key file")
+		}
+		return s.RunTLS(ctx, u.Host, c.certFile, c.keyFile)
+	default:
+		return fmt.Errorf("unsupported listen address scheme (%s)", u.Scheme)
+	}
+}
+
+func main() {
+	c, err := parseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Stop gracefully on interrupt and terminate signals
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	discoveryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	s, err := newServer(discoveryCtx, c)
+	cancel()
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.ServeMux = newServeMux(s)
+
+	log.Printf("listening on %s, proxy %s to %s", c.listen, s.APIPath, s.APIServerURL)
+	if err := run(ctx, s, c); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		wantAPIServer string
+		wantPKCE      bool
+		wantErr       bool
+	}{
+		{
+			name:          "command line",
+			args:          []string{"-api-server", "https://cmd:6443"},
+			wantAPIServer: "https://cmd:6443",
+		},
+		{
+			name:          "environment",
+			env:           map[string]string{"OC_PROXY_API_SERVER": "https://env:6443", "OC_PROXY_OAUTH_USE_PKCE": "true"},
+			wantAPIServer: "https://env:6443",
+			wantPKCE:      true,
+		},
+		{
+			name:          "command line overrides environment",
+			args:          []string{"-api-server", "https://cmd:6443"},
+			env:           map[string]string{"OC_PROXY_API_SERVER": "https://env:6443"},
+			wantAPIServer: "https://cmd:6443",
+		},
+		{
+			name:    "invalid environment value",
+			env:     map[string]string{"OC_PROXY_OAUTH_USE_PKCE": "maybe"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-no-such-flag"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			c, err := parseFlags(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if c.apiServer != tt.wantAPIServer {
+				t.Fatalf("api-server = %s, want %s", c.apiServer, tt.wantAPIServer)
+			}
+			if c.oauthUsePKCE != tt.wantPKCE {
+				t.Fatalf("oauth-use-pkce = %v, want %v", c.oauthUsePKCE, tt.wantPKCE)
+			}
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	dir := t.TempDir()
+	hmacKeyFile := filepath.Join(dir, "hmac.key")
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(hmacKeyFile, []byte("test-hmac-key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tokenFile, []byte("k8s-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{
+			name:    "missing API server",
+			args:    []string{"-oauth-disable"},
+			wantErr: true,
+		},
+		{
+			name: "bearer token file",
+			args: []string{"-api-server", "https://k8s:6443", "-oauth-disable",
+				"-jwt-token-key-file", hmacKeyFile, "-jwt-token-key-alg", "HS256", "-k8s-bearer-token-file", tokenFile},
+		},
+		{
+			name: "bearer token passthrough",
+			args: []string{"-api-server", "https://k8s:6443", "-oauth-disable", "-k8s-bearer-token-passthrough"},
+		},
+		{
+			name:    "invalid RSA key file",
+			args:    []string{"-api-server", "https://k8s:6443", "-oauth-disable", "-jwt-token-key-file", hmacKeyFile},
+			wantErr: true,
+		},
+		{
+			name:    "interactive authentication without endpoints",
+			args:    []string{"-api-server", "https://k8s:6443", "-k8s-bearer-token-passthrough"},
+			wantErr: true,
+		},
+		{
+			name: "interactive authentication",
+			args: []string{"-api-server", "https://k8s:6443", "-k8s-bearer-token-passthrough", "-oauth-client-id", "gateway",
+				"-oauth-auth-url", "https://issuer/authorize", "-oauth-token-url", "https://issuer/token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseFlags(tt.args)
+			if err != nil {
+				t.Fatalf("parseFlags() error = %v", err)
+			}
+
+			s, err := newServer(context.Background(), c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && s.InteractiveAuth == c.oauthDisable {
+				t.Fatalf("InteractiveAuth = %v with oauth-disable %v", s.InteractiveAuth, c.oauthDisable)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	ocgatev1beta1 "github.com/yaacov/oc-gate-operator/api/v1beta1"
 )
@@ -22,14 +23,14 @@ func (s Server) GataToken(w http.ResponseWriter, r *http.Request) {
 
 	// Check request method, we only allow post requests.
 	if r.Method != http.MethodPost {
-		handleError(w, fmt.Errorf("%s is not allowed", r.Method))
+		handleError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not allowed", r.Method))
 		return
 	}
 
 	// Get bearer token from request
 	bearer, err := GetRequestBearerToken(r)
 	if err != nil {
-		handleError(w, fmt.Errorf("fail to get authorization: %+v", err))
+		handleError(w, http.StatusUnauthorized, fmt.Errorf("fail to get authorization: %+v", err))
 		return
 	}
 
@@ -42,7 +43,7 @@ func (s Server) GataToken(w http.ResponseWriter, r *http.Request) {
 
 	// Parse request body as gate token spec
 	if err := json.NewDecoder(r.Body).Decode(&gateToken); err != nil {
-		handleError(w, fmt.Errorf("fail to parse token request: %+v", err))
+		handleError(w, http.StatusBadRequest, fmt.Errorf("fail to parse token request: %+v", err))
 		return
 	}
 
@@ -52,20 +53,20 @@ func (s Server) GataToken(w http.ResponseWriter, r *http.Request) {
 	// Get private key secret for signing JWT
 	privateKeyBytes, err := s.getPrivateKey(gateToken.Namespace, bearer)
 	if err != nil {
-		handleError(w, fmt.Errorf("secret error: %+v", err))
+		handleError(w, http.StatusForbidden, fmt.Errorf("secret error: %+v", err))
 		return
 	}
 
 	// Sign the token
 	if err := singToken(gateToken, privateKeyBytes); err != nil {
-		handleError(w, fmt.Errorf("fail to sign token: %+v", err))
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("fail to sign token: %+v", err))
 		return
 	}
 
 	// Return a signed token as a JSON struct
 	b, err := json.Marshal(gateToken)
 	if err != nil {
-		handleError(w, fmt.Errorf("fail to marshal token: %+v", err))
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("fail to marshal token: %+v", err))
 		return
 	}
 
@@ -73,10 +74,27 @@ func (s Server) GataToken(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
-func handleError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusForbidden)
+// handleError writes a Kubernetes style Status error response with the given HTTP status code.
+func handleError(w http.ResponseWriter, code int, err error) {
+	reason := strings.ReplaceAll(http.StatusText(code), " ", "")
+	if code == http.StatusInternalServerError {
+		reason = "InternalError"
+	}
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"kind":       "Status",
+		"apiVersion": "v1",
+		"metadata":   map[string]string{},
+		"api":        "ocgate",
+		"status":     "Failure",
+		"message":    err.Error(),
+		"reason":     reason,
+		"code":       code,
+	})
+
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, "{\"kind\": \"Status\", \"api\": \"ocgate\", \"status\": \"Forbidden\", \"message\": \"%s\",\"code\": %d}", err, http.StatusForbidden)
+	w.WriteHeader(code)
+	w.Write(b)
 }
 
 // GetRequestBearerToken parses a request and get the token to pass to k8s API
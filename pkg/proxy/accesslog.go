@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	// AccessLogCombined is the Apache Combined Log Format.
+	AccessLogCombined = "combined"
+	// AccessLogJSON writes one JSON object per request.
+	AccessLogJSON = "json"
+
+	// combinedTimeFormat is the request time format of the Combined Log Format.
+	combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+)
+
+// accessLogRecord is a JSON access log line.
+type accessLogRecord struct {
+	Time           string  `json:"time"`
+	RequestID      string  `json:"request_id,omitempty"`
+	RemoteAddr     string  `json:"remote_addr"`
+	User           string  `json:"user,omitempty"`
+	Method         string  `json:"method"`
+	Path           string  `json:"path"`
+	Query          string  `json:"query,omitempty"`
+	Proto          string  `json:"proto"`
+	Status         int     `json:"status"`
+	UpstreamStatus int     `json:"upstream_status,omitempty"`
+	Bytes          int64   `json:"bytes"`
+	DurationMS     float64 `json:"duration_ms"`
+	Referer        string  `json:"referer,omitempty"`
+	UserAgent      string  `json:"user_agent,omitempty"`
+}
+
+// AccessLogMiddleware writes a line for each request to AccessLog when set, using the
+// AccessLogFormat, Combined Log Format by default. The user is the validated token subject,
+// hashed when AccessLogRedactUser is set, tokens and sensitive query parameters are never
+// written.
+func (s *Server) AccessLogMiddleware(next http.Handler) http.Handler {
+	if s.AccessLog == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := withRequestInfo(r)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		// Keep the request URL, handlers may rewrite the request path
+		requestURL := *r.URL
+
+		next.ServeHTTP(rec, r)
+
+		line, err := s.accessLogLine(r, &requestURL, info, rec, start)
+		if err != nil {
+			s.logRequestError(r, "fail to write access log", err)
+			return
+		}
+
+		s.accessLogMu.Lock()
+		defer s.accessLogMu.Unlock()
+		if _, err := s.AccessLog.Write(line); err != nil {
+			s.logRequestError(r, "fail to write access log", err)
+		}
+	})
+}
+
+// accessLogLine formats the access log line of a request.
+func (s *Server) accessLogLine(r *http.Request, requestURL *url.URL, info *requestInfo, rec *statusRecorder, start time.Time) ([]byte, error) {
+	user := s.accessLogUser(info.claims)
+	query := redactQuery(requestURL.RawQuery)
+
+	if s.AccessLogFormat == AccessLogJSON {
+		line, err := json.Marshal(accessLogRecord{
+			Time:           start.UTC().Format(time.RFC3339Nano),
+			RequestID:      RequestIDFromContext(r.Context()),
+			RemoteAddr:     s.clientIP(r),
+			User:           user,
+			Method:         r.Method,
+			Path:           requestURL.Path,
+			Query:          query,
+			Proto:          r.Proto,
+			Status:         rec.Status(),
+			UpstreamStatus: info.upstreamStatus,
+			Bytes:          rec.Bytes(),
+			DurationMS:     float64(time.Since(start).Microseconds()) / 1000,
+			Referer:        r.Referer(),
+			UserAgent:      r.UserAgent(),
+		})
+		return append(line, '\n'), err
+	}
+
+	target := requestURL.EscapedPath()
+	if query != "" {
+		target += "?" + query
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		s.clientIP(r), combinedField(user), start.Format(combinedTimeFormat),
+		r.Method, target, r.Proto, rec.Status(), combinedBytes(rec.Bytes()),
+		combinedQuote(r.Referer()), combinedQuote(r.UserAgent()))
+	return []byte(line), nil
+}
+
+// accessLogUser returns the token subject, or a short hash of it when AccessLogRedactUser is set.
+func (s *Server) accessLogUser(claims jwt.MapClaims) string {
+	sub, _ := claims["sub"].(string)
+	if sub == "" || !s.AccessLogRedactUser {
+		return sub
+	}
+
+	sum := sha256.Sum256([]byte(sub))
+	return hex.EncodeToString(sum[:8])
+}
+
+// combinedField returns a Combined Log Format field, "-" when empty.
+func combinedField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, " ", "_")
+}
+
+// combinedBytes returns the response size field, "-" when no body was written.
+func combinedBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// combinedQuote escapes a quoted Combined Log Format field.
+func combinedQuote(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(value, `\`, `\\`), `"`, `\"`)
+}
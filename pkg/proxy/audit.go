@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Audit decisions.
+const (
+	AuditAllow = "allow"
+	AuditDeny  = "deny"
+)
+
+// AuditRecord describes an authorization decision made by the authentication middleware,
+// it never holds the request token.
+type AuditRecord struct {
+	Time       time.Time
+	RequestID  string
+	Subject    string
+	Method     string
+	Path       string
+	RemoteAddr string
+	Decision   string
+	Reason     string
+	Message    string
+}
+
+// AuditLogger receives the authorization decisions made by the authentication middleware,
+// e.g. to ship them to a SIEM.
+type AuditLogger interface {
+	Audit(record AuditRecord)
+}
+
+// AuditLoggerFunc adapts a func to the AuditLogger interface.
+type AuditLoggerFunc func(record AuditRecord)
+
+// Audit calls f(record).
+func (f AuditLoggerFunc) Audit(record AuditRecord) {
+	f(record)
+}
+
+// SlogAuditLogger writes audit records to a structured logger.
+type SlogAuditLogger struct {
+	Logger *slog.Logger
+}
+
+// Audit writes the audit record as a log line.
+func (l SlogAuditLogger) Audit(record AuditRecord) {
+	logger := l.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	logger.Info("audit",
+		slog.Time("time", record.Time),
+		slog.String("request_id", record.RequestID),
+		slog.String("sub", record.Subject),
+		slog.String("method", record.Method),
+		slog.String("path", record.Path),
+		slog.String("remote_addr", record.RemoteAddr),
+		slog.String("decision", record.Decision),
+		slog.String("reason", record.Reason),
+		slog.String("message", record.Message))
+}
+
+// audit sends an authorization decision to the audit logger, if one is set.
+func (s *Server) audit(r *http.Request, claims jwt.MapClaims, decision string, reason string, err error) {
+	if s.AuditLogger == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:       time.Now(),
+		RequestID:  RequestIDFromContext(r.Context()),
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		RemoteAddr: s.clientIP(r),
+		Decision:   decision,
+		Reason:     reason,
+	}
+	if sub, ok := claims["sub"].(string); ok {
+		record.Subject = sub
+	}
+	if err != nil {
+		record.Message = err.Error()
+	}
+
+	s.AuditLogger.Audit(record)
+}
@@ -0,0 +1,44 @@
+package proxy
+
+import "net/http"
+
+// OnAuthFailure reasons of requests rejected for their token.
+const (
+	AuthFailureNoToken       = "no-token"
+	AuthFailureExpired       = "expired"
+	AuthFailureBadSignature  = "bad-signature"
+	AuthFailureWrongAudience = "wrong-audience"
+	AuthFailureRevoked       = "revoked"
+	AuthFailureInvalidToken  = "invalid-token"
+)
+
+// authFailureReason returns the OnAuthFailure reason of a rejection reason, token failures
+// are categorized, other reasons, e.g. path-denied, are returned as is.
+func authFailureReason(reason string) string {
+	switch reason {
+	case "expired-token":
+		return AuthFailureExpired
+	case "revoked-token":
+		return AuthFailureRevoked
+	case "not-yet-valid-token", "wrong-issuer":
+		return AuthFailureInvalidToken
+	default:
+		return reason
+	}
+}
+
+// authFailed calls OnAuthFailure when set, the callback never receives the request token.
+func (s *Server) authFailed(r *http.Request, reason string) {
+	if s.OnAuthFailure == nil {
+		return
+	}
+	s.OnAuthFailure(r, authFailureReason(reason))
+}
+
+// redirectToLogin rejects a request of an interactive client, and redirects it to the
+// login endpoint.
+func (s *Server) redirectToLogin(w http.ResponseWriter, r *http.Request, reason string, err error) {
+	s.audit(r, nil, AuditDeny, reason, err)
+	s.authFailed(r, reason)
+	http.Redirect(w, r, s.loginURL(r), http.StatusTemporaryRedirect)
+}
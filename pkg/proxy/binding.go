@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	ocgateSessionClientCookieName = "ocgate-session-client"
+
+	// clientIPv4PrefixLength is the network prefix of the client IPv4 address bound to a
+	// session, clients behind the same NAT may change address within the network.
+	clientIPv4PrefixLength = 24
+
+	// clientIPv6PrefixLength is the network prefix of the client IPv6 address bound to a session.
+	clientIPv6PrefixLength = 64
+)
+
+// sessionClientCookieName returns the name of the cookie binding the session cookie to the client.
+func (s *Server) sessionClientCookieName() string {
+	if s.SessionCookieName != "" {
+		return s.SessionCookieName + "-client"
+	}
+	return ocgateSessionClientCookieName
+}
+
+// clientFingerprint returns a hash of the request User-Agent, and the client IP network
+// when BindSessionToClientIP is set.
+func (s *Server) clientFingerprint(r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(r.UserAgent()))
+	if s.BindSessionToClientIP {
+		h.Write([]byte{0})
+		h.Write([]byte(clientNetwork(s.clientIP(r))))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// clientNetwork returns the network of a client IP address, or the address if it is not
+// a valid IP address.
+func clientNetwork(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(clientIPv4PrefixLength, 8*net.IPv4len)).String()
+	}
+	return ip.Mask(net.CIDRMask(clientIPv6PrefixLength, 8*net.IPv6len)).String()
+}
+
+// sessionBinding returns the MAC binding a session cookie token to a client fingerprint.
+func (s *Server) sessionBinding(fingerprint string, token string) string {
+	mac := hmac.New(sha256.New, s.cookieKey())
+	mac.Write([]byte(fingerprint))
+	mac.Write([]byte{0})
+	mac.Write([]byte(token))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// sessionFingerprint returns the client fingerprint kept in a stored session, or an
+// empty string when BindSessionToClient is not set.
+func (s *Server) sessionFingerprint(r *http.Request) string {
+	if !s.BindSessionToClient {
+		return ""
+	}
+	return s.clientFingerprint(r)
+}
+
+// setSessionBinding sets the cookie binding the session cookie token to the client,
+// when BindSessionToClient is set.
+func (s *Server) setSessionBinding(w http.ResponseWriter, r *http.Request, token string) {
+	if !s.BindSessionToClient {
+		return
+	}
+
+	cookie := s.newCookie(r, s.sessionClientCookieName(), s.sessionBinding(s.clientFingerprint(r), token))
+	s.setCookieExpiry(cookie, time.Time{})
+	http.SetCookie(w, cookie)
+}
+
+// clearSessionBinding expires the cookie binding the session cookie to the client.
+func (s *Server) clearSessionBinding(w http.ResponseWriter, r *http.Request) {
+	if !s.BindSessionToClient || s.SessionStore != nil {
+		return
+	}
+	http.SetCookie(w, expireCookie(s.newCookie(r, s.sessionClientCookieName(), "")))
+}
+
+// checkSessionBinding checks that the session of a request is used by the client that logged
+// in, using the fingerprint recorded at login. Requests without a session, or authenticated
+// using a token header, are not checked.
+func (s *Server) checkSessionBinding(r *http.Request) error {
+	if !s.BindSessionToClient || s.headerToken(r) != "" || webSocketToken(r) != "" {
+		return nil
+	}
+
+	if s.SessionStore != nil {
+		session, err := s.getSession(r)
+		if err != nil || session == nil {
+			return nil
+		}
+		if subtle.ConstantTimeCompare([]byte(session.Fingerprint), []byte(s.clientFingerprint(r))) != 1 {
+			return fmt.Errorf("session client does not match")
+		}
+		return nil
+	}
+
+	token, err := s.getSessionCookie(r)
+	if err != nil || token == "" {
+		return nil
+	}
+	cookie, err := r.Cookie(s.sessionClientCookieName())
+	if err != nil || cookie.Value == "" {
+		return fmt.Errorf("missing session client binding")
+	}
+	if !hmac.Equal([]byte(cookie.Value), []byte(s.sessionBinding(s.clientFingerprint(r), token))) {
+		return fmt.Errorf("session client does not match")
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultCircuitBreakerWindow is the time window consecutive failures are counted in.
+	defaultCircuitBreakerWindow = 10 * time.Second
+
+	// defaultCircuitBreakerCooldown is the time an open circuit fails requests before testing recovery.
+	defaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// Circuit breaker states, exported as the circuit breaker state metric.
+const (
+	circuitClosed = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// errCircuitOpen is returned when requests are not sent because the circuit is open.
+var errCircuitOpen = errors.New("circuit breaker is open, k8s API server is unavailable")
+
+// circuitBreaker is a RoundTripper that stops sending requests to a failing upstream.
+// After threshold consecutive failures within window the circuit opens and requests fail
+// fast for cooldown, then a single request is sent to test if the upstream recovered.
+type circuitBreaker struct {
+	next      http.RoundTripper
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	onState   func(state int)
+
+	mu           sync.Mutex
+	state        int
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+// newCircuitBreaker wraps a RoundTripper with a circuit breaker, zero window and cooldown
+// mean the defaults of 10s and 30s, onState is called on state changes.
+func newCircuitBreaker(next http.RoundTripper, threshold int, window time.Duration, cooldown time.Duration, onState func(state int)) *circuitBreaker {
+	if window <= 0 {
+		window = defaultCircuitBreakerWindow
+	}
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+
+	b := &circuitBreaker{next: next, threshold: threshold, window: window, cooldown: cooldown, onState: onState}
+	b.setState(circuitClosed)
+
+	return b
+}
+
+// RoundTrip sends the request if the circuit allows it, and records the result.
+func (b *circuitBreaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	probe, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.next.RoundTrip(req)
+	b.record(probe, isUpstreamFailure(req, resp, err))
+
+	return resp, err
+}
+
+// allow checks if a request can be sent, in the half-open state only one probe
+// request is sent at a time.
+func (b *circuitBreaker) allow() (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false, errCircuitOpen
+		}
+		b.setState(circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		if b.probing {
+			return false, errCircuitOpen
+		}
+		b.probing = true
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// record updates the circuit state using the result of a request.
+func (b *circuitBreaker) record(probe bool, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+		b.failures = 0
+		if failed {
+			b.open()
+		} else {
+			b.setState(circuitClosed)
+		}
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	now := time.Now()
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+
+	if b.state == circuitClosed && b.failures >= b.threshold {
+		b.open()
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = time.Now()
+	b.setState(circuitOpen)
+}
+
+func (b *circuitBreaker) setState(state int) {
+	b.state = state
+	if b.onState != nil {
+		b.onState(state)
+	}
+}
+
+// isUpstreamFailure checks if a round trip failed because the upstream is unavailable,
+// requests cancelled by the client are not failures.
+func isUpstreamFailure(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
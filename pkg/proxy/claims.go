@@ -0,0 +1,32 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// contextKey is a key for values stored in the request context.
+type contextKey struct {
+	name string
+}
+
+// ClaimsContextKey is the request context key of the JWT claims validated by
+// AuthMiddleware, the value is a jwt.MapClaims.
+var ClaimsContextKey = &contextKey{"claims"}
+
+// withClaims returns a copy of ctx holding the validated JWT claims, the claims are
+// also kept in the request info of the logging middlewares.
+func withClaims(ctx context.Context, claims jwt.MapClaims) context.Context {
+	if info := requestInfoFromContext(ctx); info != nil {
+		info.claims = claims
+	}
+	return context.WithValue(ctx, ClaimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the JWT claims validated by AuthMiddleware,
+// or nil if the request token was not validated, e.g. token passthrough.
+func ClaimsFromContext(ctx context.Context) jwt.MapClaims {
+	claims, _ := ctx.Value(ClaimsContextKey).(jwt.MapClaims)
+	return claims
+}
@@ -0,0 +1,150 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultUpstreamTimeout is the time to wait for the k8s API server response headers.
+	defaultUpstreamTimeout = 30 * time.Second
+
+	// defaultOAuthExchangeTimeout is the timeout for requests to the OAuth2 server.
+	defaultOAuthExchangeTimeout = 10 * time.Second
+)
+
+// upstreamTimeout returns the time to wait for the k8s API server response headers.
+func (s *Server) upstreamTimeout() time.Duration {
+	if s.UpstreamTimeout > 0 {
+		return s.UpstreamTimeout
+	}
+	return defaultUpstreamTimeout
+}
+
+// oauthExchangeTimeout returns the timeout for requests to the OAuth2 server.
+func (s *Server) oauthExchangeTimeout() time.Duration {
+	if s.OAuthExchangeTimeout > 0 {
+		return s.OAuthExchangeTimeout
+	}
+	return defaultOAuthExchangeTimeout
+}
+
+// oauthHTTPClient returns the HTTP client used for requests to the OAuth2 server,
+// defaults to a client using APITransport, shared by all OAuth2 requests so their
+// connections are reused.
+func (s *Server) oauthHTTPClient() *http.Client {
+	if s.OAuthHTTPClient != nil {
+		return s.OAuthHTTPClient
+	}
+
+	s.oauthClientOnce.Do(func() {
+		s.oauthClient = s.httpClient(s.oauthExchangeTimeout())
+	})
+
+	return s.oauthClient
+}
+
+// httpClient returns an HTTP client using APITransport, or a transport created by
+// NewAPITransport if not set.
+func (s *Server) httpClient(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: s.apiTransport(), Timeout: timeout}
+}
+
+// apiTransport returns APITransport, or a transport created by NewAPITransport if not
+// set, the default transport is created once so its connections are reused.
+func (s *Server) apiTransport() *http.Transport {
+	if s.APITransport != nil {
+		return s.APITransport
+	}
+
+	s.defaultTransportOnce.Do(func() {
+		s.defaultTransport = NewAPITransport(APITransportOptions{})
+	})
+
+	return s.defaultTransport
+}
+
+// proxyTransport returns the transport used to proxy requests to the k8s API server,
+// the response header timeout limits hung requests without limiting long lived
+// streams, e.g. watch, exec and log requests. The k8s API server certificate is verified
+// using the upstream CAs and the upstream host as server name, and the ClientCert is
+// presented to it when set. HTTP/2 is used unless DisableHTTP2 is set, upgrade requests
+// always use HTTP/1.1 connections. Idempotent
+// requests are retried when MaxRetries is set, a circuit breaker is used when
+// CircuitBreakerThreshold is set, and upstream calls are traced when Tracer is set.
+func (s *Server) proxyTransport(apiServerURL string, apiTransport *http.Transport, upstreamHost string) http.RoundTripper {
+	var transport *http.Transport
+	if apiTransport != nil {
+		transport = apiTransport.Clone()
+	} else {
+		transport = NewAPITransport(APITransportOptions{})
+	}
+	transport.ResponseHeaderTimeout = s.upstreamTimeout()
+
+	// Negotiate HTTP/2 when the k8s API server supports it
+	if s.DisableHTTP2 {
+		disableHTTP2(transport)
+	} else {
+		transport.ForceAttemptHTTP2 = true
+	}
+
+	// Verify the k8s API server certificate using the upstream CAs
+	rootCAs, err := s.upstreamCAs()
+	if err != nil {
+		s.logger().Error("fail to read upstream CAs", "upstream", apiServerURL, "error", err)
+	}
+	if rootCAs != nil || s.ClientCert != nil || upstreamHost != "" {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+	}
+	if rootCAs != nil {
+		transport.TLSClientConfig.RootCAs = rootCAs
+	}
+
+	// Use the upstream host as TLS server name, unless the transport sets one
+	if upstreamHost != "" && transport.TLSClientConfig.ServerName == "" {
+		transport.TLSClientConfig.ServerName = hostname(upstreamHost)
+	}
+
+	// Authenticate to the k8s API server using a client certificate
+	if s.ClientCert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*s.ClientCert}
+	}
+
+	// Send upgrade requests over HTTP/1.1 connections, the HTTP/2 transport rejects
+	// SPDY upgrades used by exec, attach and port-forward
+	var roundTripper http.RoundTripper = transport
+	if !s.DisableHTTP2 {
+		http1Transport := transport.Clone()
+		disableHTTP2(http1Transport)
+		roundTripper = &upgradeTransport{next: transport, upgrade: http1Transport}
+	}
+
+	if s.MaxRetries > 0 {
+		roundTripper = newRetryTransport(roundTripper, s.MaxRetries, s.RetryBackoff)
+	}
+
+	if s.CircuitBreakerThreshold > 0 {
+		roundTripper = newCircuitBreaker(roundTripper, s.CircuitBreakerThreshold,
+			s.CircuitBreakerWindow, s.CircuitBreakerCooldown, func(state int) {
+				s.Metrics.circuitBreakerState(apiServerURL, state)
+			})
+	}
+
+	if s.Tracer != nil {
+		roundTripper = &tracingTransport{next: roundTripper, tracer: s.Tracer}
+	}
+
+	return roundTripper
+}
+
+// hostname returns the host without the port.
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// defaultCompressionMinSize is the minimal response size compressed.
+const defaultCompressionMinSize = 1024
+
+// compressHandler compresses responses using gzip or deflate when the client accepts it,
+// responses smaller than CompressionMinSize, already encoded responses, upgraded connections
+// and streaming requests (e.g. watch and follow) are not compressed.
+func (s *Server) compressHandler(next http.Handler) http.Handler {
+	minSize := s.CompressionMinSize
+	if minSize <= 0 {
+		minSize = defaultCompressionMinSize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r)
+		if encoding == "" || isUpgradeRequest(r) || isStreamingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, minSize: minSize}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding returns the compression encoding accepted by the client, gzip is preferred.
+func acceptedEncoding(r *http.Request) string {
+	accepted := map[string]bool{}
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			if strings.ReplaceAll(strings.TrimSpace(params), " ", "") == "q=0" {
+				continue
+			}
+			accepted[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// compressWriter buffers the response until minSize bytes are written, then compresses the
+// response if it is not already encoded. Streaming requests are not wrapped, so flushing
+// before minSize keeps buffering, the reverse proxy flushes each write of chunked responses.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	code      int
+	buf       []byte
+	committed bool
+	writer    io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.committed {
+		if w.writer != nil {
+			return w.writer.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minSize {
+		if err := w.commit(w.shouldCompress()); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// shouldCompress checks the response is not already encoded.
+func (w *compressWriter) shouldCompress() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" && h.Get("Content-Encoding") != "identity" {
+		return false
+	}
+
+	// Partial content ranges refer to the uncompressed body
+	if w.code == http.StatusPartialContent {
+		return false
+	}
+
+	return !strings.HasPrefix(h.Get("Content-Type"), "image/")
+}
+
+// commit writes the response headers and the buffered body.
+func (w *compressWriter) commit(compress bool) error {
+	w.committed = true
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+
+	if compress {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+
+		if w.encoding == "gzip" {
+			w.writer = gzip.NewWriter(w.ResponseWriter)
+		} else {
+			fw, err := flate.NewWriter(w.ResponseWriter, flate.DefaultCompression)
+			if err != nil {
+				return err
+			}
+			w.writer = fw
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.code)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.writer != nil {
+		_, err := w.writer.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// Close writes any buffered response, and completes the compressed stream.
+func (w *compressWriter) Close() error {
+	if !w.committed {
+		if err := w.commit(false); err != nil {
+			return err
+		}
+	}
+
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	return nil
+}
+
+// Flush sends the compressed data written so far, a response smaller than minSize
+// is kept buffered until it is complete.
+func (w *compressWriter) Flush() {
+	if !w.committed {
+		return
+	}
+
+	if fw, ok := w.writer.(interface{ Flush() error }); ok {
+		fw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows upgrade requests, the response is not compressed.
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	w.committed = true
+	return h.Hijack()
+}
+
+// Unwrap returns the original ResponseWriter.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ConcurrencyLimitMiddleware limits the number of in-flight proxied requests to
+// MaxConcurrentRequests. When the limit is reached requests wait up to
+// ConcurrencyQueueTimeout for a slot, or fail fast, with a 503 Status response.
+// Watch, log, exec and upgrade requests are long lived and not counted, so open
+// streams can not starve short requests. When MaxConcurrentRequests is not set,
+// requests are passed to next unchanged.
+func (s *Server) ConcurrencyLimitMiddleware(next http.Handler) http.Handler {
+	if s.MaxConcurrentRequests <= 0 {
+		return next
+	}
+	slots := make(chan struct{}, s.MaxConcurrentRequests)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUpgradeRequest(r) || isStreamingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !acquireSlot(r, slots, s.ConcurrencyQueueTimeout) {
+			s.Metrics.concurrencyRejected()
+			w.Header().Set("Retry-After", "1")
+			s.writeError(w, r, http.StatusServiceUnavailable, fmt.Errorf("too many concurrent requests, retry later"))
+			return
+		}
+		s.Metrics.inflightRequests(len(slots))
+		defer func() {
+			<-slots
+			s.Metrics.inflightRequests(len(slots))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireSlot takes a slot, waiting up to timeout, or until the request is cancelled.
+func acquireSlot(r *http.Request, slots chan struct{}, timeout time.Duration) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if timeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,296 @@
+package proxy
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultOAuthScopes are the scopes requested on login when the config sets none.
+var defaultOAuthScopes = []string{"user:full"}
+
+// Config is the structured configuration file of a Server, read by LoadConfig from a
+// YAML or JSON file, e.g. mounted from a ConfigMap.
+type Config struct {
+	// APIServerURL is the k8s API server URL, required.
+	APIServerURL string `json:"apiServerURL"`
+	// APIPath is the server endpoint for API calls, defaults to "/k8s/".
+	APIPath string `json:"apiPath,omitempty"`
+	// CAFile is a PEM encoded CA bundle file used to verify the k8s API server certificate,
+	// defaults to the system CAs.
+	CAFile string `json:"caFile,omitempty"`
+	// InsecureSkipVerify skips the k8s API server certificate verification.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// DisableHTTP2 uses HTTP/1.1 for requests to the k8s API server.
+	DisableHTTP2 bool `json:"disableHTTP2,omitempty"`
+	// UpstreamTimeout is the time to wait for the k8s API server response headers, e.g. "30s".
+	UpstreamTimeout Duration `json:"upstreamTimeout,omitempty"`
+	// BaseAddress is this server base address, used for OAuth2 redirects.
+	BaseAddress string `json:"baseAddress,omitempty"`
+
+	// BearerTokenFile is the file of the operator token used for k8s API calls.
+	BearerTokenFile string `json:"bearerTokenFile,omitempty"`
+	// BearerTokenPassthrough passes the request token to the k8s API server.
+	BearerTokenPassthrough bool `json:"bearerTokenPassthrough,omitempty"`
+	// ImpersonateUsers impersonates the token subject and groups when using the operator token.
+	ImpersonateUsers bool `json:"impersonateUsers,omitempty"`
+	// TokenReview authenticates tokens using a k8s TokenReview instead of validating JWT tokens.
+	TokenReview bool `json:"tokenReview,omitempty"`
+	// ValidationCacheTTL is the time a validated token is cached, e.g. "30s".
+	ValidationCacheTTL Duration `json:"validationCacheTTL,omitempty"`
+
+	// JWT configures the keys and claims used to validate JWT tokens.
+	JWT JWTConfig `json:"jwt,omitempty"`
+	// OAuth configures interactive authentication, login is disabled when not set.
+	OAuth *OAuthConfig `json:"oauth,omitempty"`
+
+	// ReadOnly rejects mutating requests to the k8s API.
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// AllowedGroups if set, tokens must have one of the groups in their groups claim.
+	AllowedGroups []string `json:"allowedGroups,omitempty"`
+	// PathRules allow or deny requests by method and API path regular expression.
+	PathRules []PathRule `json:"pathRules,omitempty"`
+	// PathRulesDefaultDeny denies requests not matching any of the PathRules.
+	PathRulesDefaultDeny bool `json:"pathRulesDefaultDeny,omitempty"`
+}
+
+// JWTConfig is the JWT validation section of a Config.
+type JWTConfig struct {
+	// HMACKey is the base64 encoded key of HMAC signed tokens.
+	HMACKey string `json:"hmacKey,omitempty"`
+	// RSAPublicKey is the PEM encoded public key of RSA signed tokens.
+	RSAPublicKey string `json:"rsaPublicKey,omitempty"`
+	// JWKSURL is the JSON Web Key Set endpoint of RSA and ECDSA signed tokens.
+	JWKSURL string `json:"jwksURL,omitempty"`
+	// Audience if set, tokens must include it in their aud claim.
+	Audience string `json:"audience,omitempty"`
+	// Issuer if set, tokens must have it as their iss claim.
+	Issuer string `json:"issuer,omitempty"`
+}
+
+// OAuthConfig is the OAuth2 section of a Config.
+type OAuthConfig struct {
+	// ClientID is the OAuth2 client id, required.
+	ClientID string `json:"clientID"`
+	// ClientSecret is the OAuth2 client secret.
+	ClientSecret string `json:"clientSecret,omitempty"`
+	// AuthURL is the OAuth2 authorization endpoint, required.
+	AuthURL string `json:"authURL"`
+	// TokenURL is the OAuth2 token endpoint, required.
+	TokenURL string `json:"tokenURL"`
+	// RedirectURL is the OAuth2 callback URL, derived from each login request when not set.
+	RedirectURL string `json:"redirectURL,omitempty"`
+	// Scopes are the requested scopes, defaults to "user:full".
+	Scopes []string `json:"scopes,omitempty"`
+	// Issuer is the OAuth2 issuer endpoint.
+	Issuer string `json:"issuer,omitempty"`
+	// RevocationURL is the OAuth2 token revocation endpoint used on logout.
+	RevocationURL string `json:"revocationURL,omitempty"`
+	// UserInfoURL is the OpenID Connect userinfo endpoint.
+	UserInfoURL string `json:"userInfoURL,omitempty"`
+	// UsePKCE adds a PKCE code challenge to the authorization code flow.
+	UsePKCE bool `json:"usePKCE,omitempty"`
+	// AccessTypeOffline requests offline access on login, e.g. to get refresh tokens from Google.
+	AccessTypeOffline bool `json:"accessTypeOffline,omitempty"`
+	// Prompt is the prompt parameter sent on login, defaults to "consent".
+	Prompt string `json:"prompt,omitempty"`
+	// LoginEndpoint is the server login endpoint, defaults to "/auth/login".
+	LoginEndpoint string `json:"loginEndpoint,omitempty"`
+}
+
+// Duration is a time.Duration read from a duration string, e.g. "1m30s".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("invalid duration %s, expected a string e.g. \"30s\"", b)
+	}
+
+	duration, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON writes the duration as a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads a YAML or JSON config file, and returns the Server it describes.
+// Unknown fields are an error, and the Server is validated using Validate.
+func LoadConfig(path string) (*Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read config file: %+v", err)
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("fail to parse config file %s: %+v", path, err)
+	}
+
+	opts, err := config.options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %+v", path, err)
+	}
+
+	s, err := NewServer(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %+v", path, err)
+	}
+
+	return s, nil
+}
+
+// options returns the Server options of the config.
+func (c *Config) options() ([]Option, error) {
+	if c.APIServerURL == "" {
+		return nil, fmt.Errorf("missing apiServerURL")
+	}
+
+	var rootCAs *x509.CertPool
+	if c.CAFile != "" {
+		var err error
+		if rootCAs, err = readCAFile(c.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	transport := NewAPITransport(APITransportOptions{
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		DisableHTTP2:       c.DisableHTTP2,
+	})
+	opts := []Option{WithAPIServer(c.APIServerURL, transport)}
+	if c.APIPath != "" {
+		opts = append(opts, WithAPIPath(c.APIPath))
+	}
+
+	jwtOpt, err := c.JWT.option()
+	if err != nil {
+		return nil, err
+	}
+	if jwtOpt != nil {
+		opts = append(opts, jwtOpt)
+	}
+
+	if c.OAuth != nil {
+		oauthOpts, err := c.OAuth.options()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, oauthOpts...)
+	}
+
+	opts = append(opts, func(s *Server) error {
+		s.AllowInsecureUpstream = c.InsecureSkipVerify
+		s.DisableHTTP2 = c.DisableHTTP2
+		s.UpstreamTimeout = time.Duration(c.UpstreamTimeout)
+		s.BaseAddress = c.BaseAddress
+		s.BearerTokenFile = c.BearerTokenFile
+		s.BearerTokenPassthrough = c.BearerTokenPassthrough
+		s.ImpersonateUsers = c.ImpersonateUsers
+		s.TokenReviewValidation = c.TokenReview
+		s.ValidationCacheTTL = time.Duration(c.ValidationCacheTTL)
+		s.ExpectedAudience = c.JWT.Audience
+		s.ExpectedIssuer = c.JWT.Issuer
+		s.JWKSURL = c.JWT.JWKSURL
+		s.ReadOnly = c.ReadOnly
+		s.AllowedGroups = c.AllowedGroups
+		s.PathRules = c.PathRules
+		s.PathRulesDefaultDeny = c.PathRulesDefaultDeny
+		return nil
+	})
+
+	return opts, nil
+}
+
+// option returns the option setting the JWT keys, or nil if no key is set.
+func (c JWTConfig) option() (Option, error) {
+	if c.HMACKey == "" && c.RSAPublicKey == "" {
+		return nil, nil
+	}
+
+	key, rsaKey, err := c.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	return WithJWTKey(key, rsaKey), nil
+}
+
+// keys decodes the base64 HMAC key and the PEM RSA public key.
+func (c JWTConfig) keys() ([]byte, *rsa.PublicKey, error) {
+	var key []byte
+	if c.HMACKey != "" {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(c.HMACKey); err != nil {
+			return nil, nil, fmt.Errorf("invalid jwt.hmacKey, expected base64: %+v", err)
+		}
+	}
+
+	var rsaKey *rsa.PublicKey
+	if c.RSAPublicKey != "" {
+		var err error
+		if rsaKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(c.RSAPublicKey)); err != nil {
+			return nil, nil, fmt.Errorf("invalid jwt.rsaPublicKey, expected a PEM public key: %+v", err)
+		}
+	}
+
+	return key, rsaKey, nil
+}
+
+// options returns the options enabling interactive authentication.
+func (c *OAuthConfig) options() ([]Option, error) {
+	switch {
+	case c.ClientID == "":
+		return nil, fmt.Errorf("missing oauth.clientID")
+	case c.AuthURL == "":
+		return nil, fmt.Errorf("missing oauth.authURL")
+	case c.TokenURL == "":
+		return nil, fmt.Errorf("missing oauth.tokenURL")
+	}
+
+	scopes := c.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOAuthScopes
+	}
+
+	conf := &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Scopes:       scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  c.AuthURL,
+			TokenURL: c.TokenURL,
+		},
+		RedirectURL: c.RedirectURL,
+	}
+
+	return []Option{
+		WithOAuth(conf, c.Issuer),
+		WithInteractiveAuth(c.LoginEndpoint),
+		func(s *Server) error {
+			s.RevocationEndpoint = c.RevocationURL
+			s.UserInfoEndpoint = c.UserInfoURL
+			s.UsePKCE = c.UsePKCE
+			s.AccessTypeOffline = c.AccessTypeOffline
+			s.Prompt = c.Prompt
+			return nil
+		},
+	}, nil
+}
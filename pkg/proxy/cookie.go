@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// processCookieKey is used to sign cookies when no CookieSigningKey is set,
+// it is only valid for the lifetime of this process.
+var processCookieKey = mustRandomBytes(32)
+
+// cookieKey returns the key used to sign short lived cookies, a warning is logged the
+// first time the process key is used.
+func (s *Server) cookieKey() []byte {
+	if len(s.CookieSigningKey) > 0 {
+		return s.CookieSigningKey
+	}
+
+	s.cookieKeyWarnOnce.Do(func() {
+		s.logger().Warn("no cookie signing key is set, using a random key, login and session cookies are not valid after a restart or on other replicas")
+	})
+	return processCookieKey
+}
+
+// sessionCookieName returns the name of the session cookie.
+func (s *Server) sessionCookieName() string {
+	if s.SessionCookieName != "" {
+		return s.SessionCookieName
+	}
+	return ocgateSessionCookieName
+}
+
+// loginCookieName returns the name of an OAuth2 login cookie, when SessionCookieName is set
+// it replaces the "ocgate" prefix, so gateways sharing a domain use different cookies.
+func (s *Server) loginCookieName(name string) string {
+	if s.SessionCookieName != "" {
+		return s.SessionCookieName + strings.TrimPrefix(name, ocgateCookiePrefix)
+	}
+	return name
+}
+
+// oauthTokenCookieName returns the name of the cookie holding the OAuth2 token.
+func (s *Server) oauthTokenCookieName() string {
+	if s.SessionCookieName != "" {
+		return s.SessionCookieName + "-oauth"
+	}
+	return ocgateOAuthTokenCookieName
+}
+
+// encryptionKey returns the key used to encrypt the OAuth2 token cookie.
+func (s *Server) encryptionKey() []byte {
+	if len(s.CookieEncryptionKey) > 0 {
+		return s.CookieEncryptionKey
+	}
+	return s.cookieKey()
+}
+
+// cookieSameSite returns the SameSite attribute of the session cookies, defaults to Lax.
+func (s *Server) cookieSameSite() http.SameSite {
+	if s.CookieSameSite != 0 {
+		return s.CookieSameSite
+	}
+	return http.SameSiteLaxMode
+}
+
+// newCookie returns an HttpOnly cookie using the server cookie security attributes,
+// the cookie is Secure when CookieSecure is set or the request scheme is https.
+func (s *Server) newCookie(r *http.Request, name string, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   s.CookieDomain,
+		Secure:   s.CookieSecure || s.requestScheme(r) == "https",
+		SameSite: s.cookieSameSite(),
+		HttpOnly: true}
+}
+
+// newLoginCookie returns a cookie used during the login flow, the cookie must be sent when
+// the OAuth2 server redirects back to the callback, so SameSite Strict is relaxed to Lax.
+func (s *Server) newLoginCookie(r *http.Request, name string, value string) *http.Cookie {
+	cookie := s.newCookie(r, name, value)
+	if cookie.SameSite == http.SameSiteStrictMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	return cookie
+}
+
+// expireCookie marks a cookie as expired.
+func expireCookie(cookie *http.Cookie) *http.Cookie {
+	cookie.Value = ""
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+	return cookie
+}
+
+// setCookieExpiry sets the cookie expiry, when expiry is zero SessionMaxAge is used,
+// and if both are zero the cookie is a browser session cookie.
+func (s *Server) setCookieExpiry(cookie *http.Cookie, expiry time.Time) {
+	if expiry.IsZero() && s.SessionMaxAge > 0 {
+		expiry = time.Now().Add(s.SessionMaxAge)
+	}
+	if expiry.IsZero() {
+		return
+	}
+
+	maxAge := int(time.Until(expiry).Seconds())
+	if maxAge <= 0 {
+		maxAge = -1
+	}
+	cookie.MaxAge = maxAge
+	cookie.Expires = expiry
+}
+
+// setSessionCookie sets the session cookie holding the token, the cookie expires with the
+// token expiry. The token is encrypted when CookieEncryptionKey is set.
+func (s *Server) setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expiry time.Time) error {
+	value := token
+	if len(s.CookieEncryptionKey) > 0 && token != "" {
+		var err error
+		if value, err = encryptCookieValue(s.CookieEncryptionKey, token); err != nil {
+			return err
+		}
+	}
+
+	cookie := s.newCookie(r, s.sessionCookieName(), value)
+	s.setCookieExpiry(cookie, expiry)
+
+	http.SetCookie(w, cookie)
+
+	return nil
+}
+
+// getSessionCookie reads the token from the session cookie,
+// the token is decrypted when CookieEncryptionKey is set.
+func (s *Server) getSessionCookie(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(s.sessionCookieName())
+	if err != nil || cookie.Value == "" {
+		return "", err
+	}
+
+	if len(s.CookieEncryptionKey) == 0 {
+		return cookie.Value, nil
+	}
+
+	return decryptCookieValue(s.CookieEncryptionKey, cookie.Value)
+}
+
+// clearSessionCookie expires the session cookie.
+func (s *Server) clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, expireCookie(s.newCookie(r, s.sessionCookieName(), "")))
+}
+
+// setLoginCookie sets a signed short lived cookie used during the login flow.
+func (s *Server) setLoginCookie(w http.ResponseWriter, r *http.Request, name string, value string) {
+	cookie := s.newLoginCookie(r, name, signCookieValue(s.cookieKey(), value))
+	cookie.MaxAge = stateCookieMaxAge
+
+	http.SetCookie(w, cookie)
+}
+
+// readLoginCookie reads a signed short lived cookie set by setLoginCookie.
+func (s *Server) readLoginCookie(r *http.Request, name string) (string, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil || cookie.Value == "" {
+		return "", fmt.Errorf("missing %s cookie", name)
+	}
+
+	return verifyCookieValue(s.cookieKey(), cookie.Value)
+}
+
+// clearLoginCookie removes a short lived cookie set by setLoginCookie.
+func (s *Server) clearLoginCookie(w http.ResponseWriter, r *http.Request, name string) {
+	http.SetCookie(w, expireCookie(s.newLoginCookie(r, name, "")))
+}
+
+// signCookieValue appends an HMAC signature to a cookie value.
+func signCookieValue(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	sig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return fmt.Sprintf("%s.%s", value, sig)
+}
+
+// verifyCookieValue checks the HMAC signature of a signed cookie value and returns the value.
+func verifyCookieValue(key []byte, signed string) (string, error) {
+	i := strings.LastIndex(signed, ".")
+	if i < 0 {
+		return "", fmt.Errorf("cookie is not signed")
+	}
+
+	value := signed[:i]
+	if !hmac.Equal([]byte(signCookieValue(key, value)), []byte(signed)) {
+		return "", fmt.Errorf("cookie signature is not valid")
+	}
+
+	return value, nil
+}
+
+// encryptCookieValue encrypts a cookie value using AES-GCM.
+func encryptCookieValue(key []byte, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decryptCookieValue decrypts a cookie value encrypted by encryptCookieValue.
+func decryptCookieValue(key []byte, encrypted string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("encrypted cookie is too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	value, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// newGCM creates an AES-256-GCM cipher using a key derived from the given key material.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	sum := sha256.Sum256(key)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// randomString returns a URL safe encoding of n random bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func mustRandomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
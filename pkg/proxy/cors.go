@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "Accept"}
+)
+
+// CORSConfig holds the cross origin resource sharing settings for browser clients.
+type CORSConfig struct {
+	// AllowedOrigins is a list of allowed origins, "*" allows any origin, and can not be
+	// used with AllowCredentials.
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowedMethods []string
+	// AllowedHeaders defaults to Authorization, Content-Type and Accept.
+	AllowedHeaders []string
+	// AllowCredentials allows browsers to send the session cookie with cross origin requests.
+	AllowCredentials bool
+	// MaxAge is the time browsers may cache a preflight response.
+	MaxAge time.Duration
+}
+
+// CORSMiddleware answers CORS preflight requests locally, and adds the CORS headers to responses.
+// When CORS is not configured, requests are passed to next unchanged.
+func (s *Server) CORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if s.CORS == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowOrigin, ok := s.CORS.allowOrigin(origin)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Echo listed origins, any other origin gets a wildcard, that browsers do not
+		// allow for credentialed requests
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if s.CORS.AllowCredentials && allowOrigin != "*" {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// Handle preflight requests
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			methods := s.CORS.AllowedMethods
+			if len(methods) == 0 {
+				methods = defaultCORSMethods
+			}
+			headers := s.CORS.AllowedHeaders
+			if len(headers) == 0 {
+				headers = defaultCORSHeaders
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if s.CORS.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(s.CORS.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowOrigin checks if an origin is allowed, and returns the Access-Control-Allow-Origin
+// value, the origin when it is listed, or "*" when it is allowed by the wildcard.
+func (c *CORSConfig) allowOrigin(origin string) (string, bool) {
+	wildcard := false
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.TrimSpace(allowed)
+		if strings.EqualFold(allowed, origin) {
+			return origin, true
+		}
+		wildcard = wildcard || allowed == "*"
+	}
+
+	if wildcard {
+		return "*", true
+	}
+	return "", false
+}
+
+// hasWildcardOrigin checks if any origin is allowed.
+func (c *CORSConfig) hasWildcardOrigin() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if strings.TrimSpace(allowed) == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// stripCORSHeaders removes CORS headers set by the k8s API server,
+// the proxy CORS middleware sets its own.
+func stripCORSHeaders(resp *http.Response) error {
+	for name := range resp.Header {
+		if strings.HasPrefix(name, "Access-Control-") {
+			resp.Header.Del(name)
+		}
+	}
+	return nil
+}
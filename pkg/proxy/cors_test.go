@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	tests := []struct {
+		name            string
+		cors            *CORSConfig
+		origin          string
+		wantOrigin      string
+		wantCredentials string
+	}{
+		{
+			name:            "listed origin with credentials",
+			cors:            &CORSConfig{AllowedOrigins: []string{"https://console.example.com"}, AllowCredentials: true},
+			origin:          "https://console.example.com",
+			wantOrigin:      "https://console.example.com",
+			wantCredentials: "true",
+		},
+		{
+			name:       "listed origin without credentials",
+			cors:       &CORSConfig{AllowedOrigins: []string{"https://console.example.com"}},
+			origin:     "https://console.example.com",
+			wantOrigin: "https://console.example.com",
+		},
+		{
+			name:   "not listed origin",
+			cors:   &CORSConfig{AllowedOrigins: []string{"https://console.example.com"}, AllowCredentials: true},
+			origin: "https://evil.example.com",
+		},
+		{
+			name:       "wildcard origin",
+			cors:       &CORSConfig{AllowedOrigins: []string{"*"}},
+			origin:     "https://evil.example.com",
+			wantOrigin: "*",
+		},
+		{
+			name:            "listed origin before wildcard",
+			cors:            &CORSConfig{AllowedOrigins: []string{"*", " https://console.example.com "}, AllowCredentials: true},
+			origin:          "https://console.example.com",
+			wantOrigin:      "https://console.example.com",
+			wantCredentials: "true",
+		},
+		{
+			name:       "wildcard origin never allows credentials",
+			cors:       &CORSConfig{AllowedOrigins: []string{"*", "https://console.example.com"}, AllowCredentials: true},
+			origin:     "https://evil.example.com",
+			wantOrigin: "*",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{CORS: tt.cors}
+
+			r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+			r.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+			s.CORSMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Fatalf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCredentials {
+				t.Fatalf("Access-Control-Allow-Credentials = %q, want %q", got, tt.wantCredentials)
+			}
+		})
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	tests := []struct {
+		name    string
+		cors    *CORSConfig
+		wantErr bool
+	}{
+		{name: "listed origins with credentials", cors: &CORSConfig{AllowedOrigins: []string{"https://console.example.com"}, AllowCredentials: true}},
+		{name: "wildcard origin", cors: &CORSConfig{AllowedOrigins: []string{"*"}}},
+		{name: "wildcard origin with credentials", cors: &CORSConfig{AllowedOrigins: []string{"https://console.example.com", " * "}, AllowCredentials: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIServerURL: "https://kubernetes.default.svc", APIPath: "/k8s/", BearerToken: "token", JWTTokenKey: testJWTKey, CORS: tt.cors}
+
+			if err := s.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// oidcDiscoveryPath is the OpenID Connect discovery document path, relative to the issuer.
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+	// oidcDiscoveryCacheTTL is the time a discovery document is cached.
+	oidcDiscoveryCacheTTL = time.Hour
+
+	// oidcDiscoveryTimeout is the timeout for fetching the discovery document.
+	oidcDiscoveryTimeout = 10 * time.Second
+)
+
+// OIDCDiscovery is an OpenID Connect provider discovery document.
+type OIDCDiscovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	RevocationEndpoint    string   `json:"revocation_endpoint"`
+	UserinfoEndpoint      string   `json:"userinfo_endpoint"`
+	EndSessionEndpoint    string   `json:"end_session_endpoint"`
+	ScopesSupported       []string `json:"scopes_supported"`
+}
+
+// oidcDiscoveryCache caches discovery documents by issuer URL.
+var oidcDiscoveryCache = struct {
+	sync.Mutex
+	docs map[string]cachedDiscovery
+}{docs: map[string]cachedDiscovery{}}
+
+type cachedDiscovery struct {
+	doc     *OIDCDiscovery
+	fetched time.Time
+}
+
+// DiscoverOIDC fetches the OpenID Connect discovery document of an issuer, documents are
+// cached for an hour. The HTTP client is taken from ctx using the oauth2.HTTPClient key,
+// defaults to http.DefaultClient.
+func DiscoverOIDC(ctx context.Context, issuerURL string) (*OIDCDiscovery, error) {
+	issuerURL = strings.TrimSuffix(issuerURL, "/")
+
+	oidcDiscoveryCache.Lock()
+	defer oidcDiscoveryCache.Unlock()
+
+	if cached, ok := oidcDiscoveryCache.docs[issuerURL]; ok && time.Since(cached.fetched) < oidcDiscoveryCacheTTL {
+		return cached.doc, nil
+	}
+
+	doc, err := fetchOIDCDiscovery(ctx, issuerURL)
+	if err != nil {
+		return nil, err
+	}
+	oidcDiscoveryCache.docs[issuerURL] = cachedDiscovery{doc: doc, fetched: time.Now()}
+
+	return doc, nil
+}
+
+// fetchOIDCDiscovery gets and checks the discovery document of an issuer.
+func fetchOIDCDiscovery(ctx context.Context, issuerURL string) (*OIDCDiscovery, error) {
+	client := http.DefaultClient
+	if c, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && c != nil {
+		client = c
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oidcDiscoveryTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuerURL+oidcDiscoveryPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get OIDC discovery document: %+v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get OIDC discovery document: %+v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to get OIDC discovery document: %s", resp.Status)
+	}
+
+	var doc OIDCDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("fail to parse OIDC discovery document: %+v", err)
+	}
+
+	// The issuer must match the URL used to get the document (OpenID Connect Discovery 4.3)
+	if strings.TrimSuffix(doc.Issuer, "/") != issuerURL {
+		return nil, fmt.Errorf("OIDC issuer (%s) does not match the discovery URL (%s)", doc.Issuer, issuerURL)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, fmt.Errorf("OIDC discovery document is missing the authorization or token endpoint")
+	}
+
+	return &doc, nil
+}
+
+// WithOIDCDiscovery configures the OAuth2 endpoints, JWKS URL, revocation and userinfo
+// endpoints and expected issuer from an OpenID Connect discovery document. Options applied later may
+// override individual endpoints.
+func WithOIDCDiscovery(doc *OIDCDiscovery, clientID string, clientSecret string) Option {
+	return func(s *Server) error {
+		if doc == nil {
+			return fmt.Errorf("missing OIDC discovery document")
+		}
+
+		s.Auth2Config = &oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Scopes:       []string{"openid"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  doc.AuthorizationEndpoint,
+				TokenURL: doc.TokenEndpoint,
+			},
+		}
+		s.IssuerEndpoint = doc.Issuer
+		s.ExpectedIssuer = doc.Issuer
+		s.JWKSURL = doc.JWKSURI
+		s.RevocationEndpoint = doc.RevocationEndpoint
+		s.UserInfoEndpoint = doc.UserinfoEndpoint
+		return nil
+	}
+}
+
+// NewServerFromOIDCDiscovery creates a proxy Server configured using the OpenID Connect
+// discovery document of issuerURL, opts are applied after the discovered configuration
+// and may override individual endpoints.
+func NewServerFromOIDCDiscovery(ctx context.Context, issuerURL string, clientID string, clientSecret string, opts ...Option) (*Server, error) {
+	doc, err := DiscoverOIDC(ctx, issuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewServer(append([]Option{WithOIDCDiscovery(doc, clientID, clientSecret)}, opts...)...)
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"bytes"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// defaultErrorHTMLTemplate is the error page rendered for browsers.
+var defaultErrorHTMLTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Code}} {{.Reason}}</title></head>
+<body>
+<h1>{{.Code}} {{.Reason}}</h1>
+<p>{{.Message}}</p>
+{{if .LoginURL}}<p><a href="{{.LoginURL}}">Login</a></p>{{end}}
+{{if .RequestID}}<p><small>Request ID: {{.RequestID}}</small></p>{{end}}
+</body>
+</html>
+`))
+
+// ErrorPage is the data used to render the ErrorHTMLTemplate.
+type ErrorPage struct {
+	Code      int
+	Reason    string
+	Message   string
+	RequestID string
+	// LoginURL is the login endpoint, set when using interactive authentication.
+	LoginURL string
+}
+
+// writeError writes an error response, requests that prefer text/html get an HTML error page,
+// other requests get a Kubernetes style Status.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, code int, err error) {
+	if !prefersHTML(r) {
+		handleError(w, code, err)
+		return
+	}
+
+	page := ErrorPage{
+		Code:      code,
+		Reason:    statusReason(code),
+		Message:   err.Error(),
+		RequestID: w.Header().Get(requestIDHeader),
+	}
+	if s.InteractiveAuth {
+		page.LoginURL = s.LoginEndpoint
+	}
+
+	tmpl := s.ErrorHTMLTemplate
+	if tmpl == nil {
+		tmpl = defaultErrorHTMLTemplate
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, page); err != nil {
+		s.logRequestError(r, "fail to render error page", err)
+		handleError(w, code, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(b.Bytes())
+}
+
+// prefersHTML checks if the request Accept header prefers text/html over application/json.
+func prefersHTML(r *http.Request) bool {
+	htmlQ, jsonQ := 0.0, 0.0
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+
+			switch mediaType {
+			case "text/html":
+				htmlQ = q
+			case "application/json":
+				jsonQ = q
+			}
+		}
+	}
+
+	return htmlQ > 0 && htmlQ > jsonQ
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// exchangeFailure describes a failed OAuth2 token exchange.
+type exchangeFailure struct {
+	// reason is the log and metrics label, e.g. "invalid-grant" or "idp-unavailable".
+	reason string
+	// code is the HTTP status code returned to the user.
+	code int
+	// message is an actionable error message for the user.
+	message string
+	// idpError is the OAuth2 error code returned by the IdP (RFC 6749 5.2), if any.
+	idpError string
+}
+
+// categorizeExchangeError categorizes a token exchange error, so network errors, invalid
+// authorization codes and IdP failures are logged, counted and reported distinctly.
+func categorizeExchangeError(err error) exchangeFailure {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		idpError := oauthErrorCode(retrieveErr.Body)
+		status := 0
+		if retrieveErr.Response != nil {
+			status = retrieveErr.Response.StatusCode
+		}
+
+		switch {
+		case status >= 500:
+			return exchangeFailure{"idp-unavailable", http.StatusBadGateway,
+				"the identity provider failed to issue a token, please try again later", idpError}
+		case idpError == "invalid_grant":
+			return exchangeFailure{"invalid-grant", http.StatusUnauthorized,
+				"the authorization code is invalid or expired, please log in again", idpError}
+		case idpError == "invalid_client" || idpError == "unauthorized_client" || status == http.StatusUnauthorized:
+			return exchangeFailure{"invalid-client", http.StatusInternalServerError,
+				"the identity provider rejected the gateway client credentials, please contact the administrator", idpError}
+		default:
+			return exchangeFailure{"idp-rejected", http.StatusUnauthorized,
+				"the identity provider rejected the token request, please log in again", idpError}
+		}
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return exchangeFailure{"timeout", http.StatusGatewayTimeout,
+			"the identity provider did not respond in time, please try again later", ""}
+	case errors.Is(err, context.Canceled):
+		return exchangeFailure{"canceled", http.StatusBadRequest,
+			"the login request was canceled", ""}
+	case errors.As(err, &netErr):
+		return exchangeFailure{"network", http.StatusBadGateway,
+			"the identity provider is not reachable, please try again later", ""}
+	default:
+		return exchangeFailure{"unknown", http.StatusInternalServerError,
+			"fail to get a token from the identity provider", ""}
+	}
+}
+
+// oauthErrorCode returns the error code of an OAuth2 error response body, encoded
+// as JSON or, by some providers, as a form.
+func oauthErrorCode(body []byte) string {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err == nil {
+		return resp.Error
+	}
+
+	if values, err := url.ParseQuery(strings.TrimSpace(string(body))); err == nil {
+		return values.Get("error")
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// trustedNets returns the parsed TrustedProxies list, single IP addresses are
+// treated as a /32 or /128 network, invalid entries are ignored.
+func (s *Server) trustedNets() []*net.IPNet {
+	s.trustedOnce.Do(func() {
+		for _, cidr := range s.TrustedProxies {
+			cidr = strings.TrimSpace(cidr)
+			if !strings.Contains(cidr, "/") {
+				if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+					cidr = cidr + "/32"
+				} else {
+					cidr = cidr + "/128"
+				}
+			}
+
+			if _, n, err := net.ParseCIDR(cidr); err == nil {
+				s.trusted = append(s.trusted, n)
+			}
+		}
+	})
+
+	return s.trusted
+}
+
+// isTrustedProxy checks if an IP address belongs to a trusted proxy.
+func (s *Server) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range s.trustedNets() {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP address of the client, when the request peer is a trusted
+// proxy the address is taken from the X-Forwarded-For header, skipping trusted proxies.
+func (s *Server) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if !s.isTrustedProxy(ip) {
+		return ip
+	}
+
+	// Walk the forwarded addresses from the closest proxy to the client
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if addr == "" {
+			continue
+		}
+
+		ip = addr
+		if !s.isTrustedProxy(addr) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// requestScheme returns the scheme used by the client, when the request peer is a
+// trusted proxy the scheme is taken from the X-Forwarded-Proto header.
+func (s *Server) requestScheme(r *http.Request) string {
+	if s.isTrustedProxy(remoteIP(r)) {
+		proto := strings.ToLower(strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]))
+		if proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// requestHost returns the host used by the client, when the request peer is a
+// trusted proxy the host is taken from the X-Forwarded-Host header.
+func (s *Server) requestHost(r *http.Request) string {
+	if s.isTrustedProxy(remoteIP(r)) {
+		if host := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Host"), ",")[0]); host != "" {
+			return host
+		}
+	}
+
+	return r.Host
+}
+
+// redirectURL returns the OAuth2 redirect URL, a relative RedirectURL is resolved
+// against the scheme and host used by the client.
+func (s *Server) redirectURL(r *http.Request, conf *oauth2.Config) string {
+	redirectURL := conf.RedirectURL
+	if !strings.HasPrefix(redirectURL, "/") {
+		return redirectURL
+	}
+
+	return s.requestScheme(r) + "://" + s.requestHost(r) + redirectURL
+}
+
+// remoteIP returns the IP address of the request peer.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
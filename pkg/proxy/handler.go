@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// Handler returns a Handler serving the API path and the Routes prefixes, wrapped by the
+// recommended middleware stack:
+//
+//	TracingMiddleware → CORSMiddleware → RateLimitMiddleware → AuthMiddleware →
+//	RequestTimeoutMiddleware → ConcurrencyLimitMiddleware → APIProxy / RoutesProxy
+//
+// Requests are logged by AuthMiddleware and instrumented by the proxy, requests for other
+// paths get 404. The request ID and access log middlewares apply to all the server endpoints,
+// and are added once by ServeHandler. The middlewares are exported to compose a different stack.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(s.APIPath, s.chain(s.APIProxy()))
+	if len(s.Routes) > 0 {
+		routesProxy := s.chain(s.RoutesProxy())
+		for prefix := range s.Routes {
+			mux.Handle(prefix, routesProxy)
+		}
+	}
+
+	return mux
+}
+
+// ServeHandler wraps the handler of all the server endpoints, e.g. a ServeMux serving Handler
+// and the login endpoints, with the server wide middlewares:
+//
+//	RequestIDMiddleware → AccessLogMiddleware → next
+//
+// Run and RunTLS serve ServeMux using it.
+func (s *Server) ServeHandler(next http.Handler) http.Handler {
+	return s.RequestIDMiddleware(s.AccessLogMiddleware(next))
+}
+
+// chain wraps a proxy handler with the authentication and request limiting middlewares.
+func (s *Server) chain(proxy http.Handler) http.Handler {
+	return s.TracingMiddleware(s.CORSMiddleware(s.RateLimitMiddleware(s.AuthMiddleware(s.RequestTimeoutMiddleware(s.ConcurrencyLimitMiddleware(proxy))))))
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestServeHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{name: "API request", path: "/k8s/api/v1/pods", token: signHS256(t, testJWTKey, jwt.MapClaims{"sub": "user", "apiGroups": []interface{}{"*"}}), wantStatus: http.StatusOK},
+		{name: "rejected API request", path: "/k8s/api/v1/pods", wantStatus: http.StatusUnauthorized},
+		{name: "other endpoint", path: "/healthz", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accessLog := &bytes.Buffer{}
+			s := &Server{APIPath: "/k8s/", APIServerURL: upstream.URL, BearerToken: "token", JWTTokenKey: testJWTKey, AccessLog: accessLog}
+
+			mux := http.NewServeMux()
+			mux.Handle(s.APIPath, s.Handler())
+			mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHandler(mux).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if ids := w.Header().Values(requestIDHeader); len(ids) != 1 || !validRequestID(ids[0]) {
+				t.Fatalf("%s = %q, want a single request ID", requestIDHeader, ids)
+			}
+			if lines := strings.Count(accessLog.String(), "\n"); lines != 1 {
+				t.Fatalf("access log lines = %d, want 1:\n%s", lines, accessLog)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// stripRequestHeaders removes headers that must not reach the k8s API server, the
+// session cookies, proxy credentials and hop-by-hop headers. Upgrade requests keep the
+// Connection header, used by the reverse proxy to switch protocols.
+func stripRequestHeaders(r *http.Request) {
+	r.Header.Del("Cookie")
+	r.Header.Del("Keep-Alive")
+	if !isUpgradeRequest(r) {
+		r.Header.Del("Connection")
+	}
+
+	for name := range r.Header {
+		if strings.HasPrefix(name, "Proxy-") {
+			r.Header.Del(name)
+		}
+	}
+}
+
+// upgradeRequestHeaders are required to switch protocols, and are kept on upgrade requests
+// when forwarding only allowed headers.
+var upgradeRequestHeaders = []string{
+	"Connection",
+	"Upgrade",
+	"Sec-Websocket-Key",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Protocol",
+	"Sec-Websocket-Extensions",
+	"X-Stream-Protocol-Version",
+}
+
+// allowedHeaderSet returns the canonical names of the headers forwarded to the k8s API
+// server, or nil if all headers are forwarded. Headers set by the proxy, Authorization,
+// the request ID and the impersonation headers, are always forwarded.
+func (s *Server) allowedHeaderSet() map[string]bool {
+	if len(s.AllowedRequestHeaders) == 0 {
+		return nil
+	}
+
+	allowed := map[string]bool{
+		"Authorization": true,
+		requestIDHeader: true,
+	}
+	if s.ImpersonateUsers {
+		allowed["Impersonate-User"] = true
+		allowed["Impersonate-Group"] = true
+	}
+	for _, name := range s.AllowedRequestHeaders {
+		allowed[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+	}
+
+	return allowed
+}
+
+// filterRequestHeaders removes headers not in allowed, upgrade requests keep the headers
+// required to switch protocols, a nil allowed set keeps all headers.
+func filterRequestHeaders(r *http.Request, allowed map[string]bool) {
+	if allowed == nil {
+		return
+	}
+
+	upgrade := isUpgradeRequest(r)
+	for name := range r.Header {
+		if allowed[name] || (upgrade && isUpgradeRequestHeader(name)) {
+			continue
+		}
+		r.Header.Del(name)
+	}
+}
+
+func isUpgradeRequestHeader(name string) bool {
+	for _, h := range upgradeRequestHeaders {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// internalResponseHeaders are set by the k8s API server and describe its internals,
+// e.g. audit and API priority and fairness ids.
+var internalResponseHeaders = []string{
+	"Audit-Id",
+	"X-Kubernetes-Pf-Flowschema-Uid",
+	"X-Kubernetes-Pf-Prioritylevel-Uid",
+}
+
+// SecureResponseHeaders is a ResponseHeaderModifier adding security headers to API responses,
+// "X-Content-Type-Options: nosniff" and "Cache-Control: no-store", and removing k8s API server
+// headers describing its internals.
+func SecureResponseHeaders(resp *http.Response) error {
+	resp.Header.Set("X-Content-Type-Options", "nosniff")
+	resp.Header.Set("Cache-Control", "no-store")
+	for _, name := range internalResponseHeaders {
+		resp.Header.Del(name)
+	}
+	return nil
+}
+
+// modifyResponse returns the reverse proxy ModifyResponse func, replacing k8s API server 401
+// responses, removing the k8s API server CORS headers and calling ResponseHeaderModifier.
+// Only the headers are modified, the body is not read, so streaming responses are not buffered.
+func (s *Server) modifyResponse() func(*http.Response) error {
+	return func(resp *http.Response) error {
+		recordUpstreamStatus(resp)
+		s.handleUpstreamUnauthorized(resp)
+		if s.CORS != nil {
+			stripCORSHeaders(resp)
+		}
+		if s.ResponseHeaderModifier != nil {
+			return s.ResponseHeaderModifier(resp)
+		}
+		return nil
+	}
+}
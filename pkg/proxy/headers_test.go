@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// headerUpstream returns a fake k8s API server, sending the headers of each request
+// it receives to headers.
+func headerUpstream(headers chan<- http.Header) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}))
+}
+
+func TestAPIProxyStripRequestHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upstream := headerUpstream(headers)
+	defer upstream.Close()
+
+	tests := []struct {
+		name          string
+		header        string
+		value         string
+		wantForwarded bool
+	}{
+		{name: "session cookie", header: "Cookie", value: ocgateSessionCookieName + "=session-token"},
+		{name: "other cookie", header: "Cookie", value: "theme=dark"},
+		{name: "keep alive", header: "Keep-Alive", value: "timeout=5"},
+		{name: "connection", header: "Connection", value: "X-Hop"},
+		{name: "proxy authorization", header: "Proxy-Authorization", value: "Basic dXNlcjpwYXNz"},
+		{name: "proxy connection", header: "Proxy-Connection", value: "keep-alive"},
+		{name: "end to end header", header: "Accept", value: "application/json", wantForwarded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIPath: "/k8s/", APIServerURL: upstream.URL}
+
+			r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+			r.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			s.APIProxy().ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			got := <-headers
+			if forwarded := got.Get(tt.header) != ""; forwarded != tt.wantForwarded {
+				t.Fatalf("upstream %s header = %q, want forwarded %v", tt.header, got.Get(tt.header), tt.wantForwarded)
+			}
+			if cookies := (&http.Request{Header: got}).Cookies(); len(cookies) > 0 {
+				t.Fatalf("upstream received cookies %v", cookies)
+			}
+		})
+	}
+}
+
+func TestAPIProxyAllowedRequestHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upstream := headerUpstream(headers)
+	defer upstream.Close()
+
+	tests := []struct {
+		name             string
+		allowedHeaders   []string
+		impersonateUsers bool
+		header           string
+		wantForwarded    bool
+	}{
+		{name: "no allow list", header: "X-Custom", wantForwarded: true},
+		{name: "allowed header", allowedHeaders: []string{"X-Custom"}, header: "X-Custom", wantForwarded: true},
+		{name: "allowed header case", allowedHeaders: []string{" x-custom "}, header: "X-Custom", wantForwarded: true},
+		{name: "disallowed header", allowedHeaders: []string{"Accept"}, header: "X-Custom"},
+		{name: "disallowed impersonation header", allowedHeaders: []string{"Accept"}, header: "Impersonate-User"},
+		{name: "impersonation header set by the proxy", allowedHeaders: []string{"Accept"}, impersonateUsers: true, header: "Impersonate-User", wantForwarded: true},
+		{name: "Authorization", allowedHeaders: []string{"Accept"}, header: "Authorization", wantForwarded: true},
+		{name: "request ID", allowedHeaders: []string{"Accept"}, header: requestIDHeader, wantForwarded: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIPath: "/k8s/", APIServerURL: upstream.URL, AllowedRequestHeaders: tt.allowedHeaders, ImpersonateUsers: tt.impersonateUsers}
+
+			r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+			r.Header.Set(tt.header, "value")
+			w := httptest.NewRecorder()
+			s.APIProxy().ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			got := <-headers
+			if forwarded := got.Get(tt.header) != ""; forwarded != tt.wantForwarded {
+				t.Fatalf("upstream %s header = %q, want forwarded %v", tt.header, got.Get(tt.header), tt.wantForwarded)
+			}
+		})
+	}
+}
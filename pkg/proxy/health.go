@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultReadyCacheInterval is the time a readiness check result is kept.
+	defaultReadyCacheInterval = 10 * time.Second
+
+	// readyCheckTimeout is the timeout for checking an upstream server.
+	readyCheckTimeout = 2 * time.Second
+)
+
+// HealthHandler return a Handler func that always reports the server is alive.
+func (s *Server) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "ok")
+	})
+}
+
+// ReadyHandler return a Handler func that reports if the k8s API server and the
+// OAuth2 issuer are reachable, the check result is cached for ReadyCacheInterval.
+func (s *Server) ReadyHandler() http.Handler {
+	var mu sync.Mutex
+	var checked time.Time
+	var lastErr error
+
+	interval := s.ReadyCacheInterval
+	if interval <= 0 {
+		interval = defaultReadyCacheInterval
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if time.Since(checked) > interval {
+			lastErr = s.checkReady()
+			checked = time.Now()
+		}
+		err := lastErr
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain")
+		if err != nil {
+			s.logRequestError(r, "not ready", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "not ready: %s", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "ok")
+	})
+}
+
+// checkReady checks that the upstream servers are reachable.
+func (s *Server) checkReady() error {
+	if err := s.checkUpstream(s.APIServerURL + "/healthz"); err != nil {
+		return fmt.Errorf("k8s API server: %+v", err)
+	}
+
+	if s.InteractiveAuth && s.IssuerEndpoint != "" {
+		issuer := s.IssuerEndpoint
+		if !strings.Contains(issuer, "://") {
+			issuer = "https://" + issuer
+		}
+
+		if err := s.checkUpstream(issuer); err != nil {
+			return fmt.Errorf("OAuth2 issuer: %+v", err)
+		}
+	}
+
+	return nil
+}
+
+// checkUpstream sends a request to an upstream server, any response that is not
+// a server error means the server is reachable.
+func (s *Server) checkUpstream(url string) error {
+	client := s.httpClient(readyCheckTimeout)
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+// errMissingIDToken is returned when id_token verification is required and the
+// token exchange response has no id_token.
+var errMissingIDToken = errors.New("token response is missing the id_token")
+
+// idTokenKey returns the key used to verify an id_token signature, HMAC signed
+// id_tokens use the OAuth2 client secret (OpenID Connect Core 10.1), other id_tokens
+// use the provider keys, or the server keys when provider is nil.
+func (s *Server) idTokenKey(provider *Provider, conf *oauth2.Config) jwt.Keyfunc {
+	return func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			if conf.ClientSecret == "" {
+				return nil, fmt.Errorf("missing client secret for HMAC signed id_token")
+			}
+			return []byte(conf.ClientSecret), nil
+		}
+
+		if provider != nil {
+			return s.providerTokenKey(provider, t)
+		}
+		return s.tokenKey(t)
+	}
+}
+
+// verifyIDToken verifies the id_token returned by the token exchange of an identity
+// provider, or of the server Auth2Config when provider is nil, its signature, issuer,
+// audience and expiry, and returns the id_token claims.
+func (s *Server) verifyIDToken(tok *oauth2.Token, provider *Provider, conf *oauth2.Config) (jwt.MapClaims, error) {
+	idToken, _ := tok.Extra("id_token").(string)
+	if idToken == "" {
+		return nil, errMissingIDToken
+	}
+
+	jwtToken, err := authenticateToken(idToken, s.idTokenKey(provider, conf))
+	if err != nil {
+		return nil, fmt.Errorf("id_token invalid: %v", err)
+	}
+
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok || !jwtToken.Valid {
+		return nil, fmt.Errorf("id_token invalid")
+	}
+
+	// Validate id_token expiry, the exp claim is required
+	if !claims.VerifyExpiresAt(time.Now().Add(-s.ClockSkew).Unix(), true) {
+		return nil, fmt.Errorf("id_token expired")
+	}
+
+	// Validate id_token issuer, the iss claim is required
+	issuer := s.ExpectedIssuer
+	if issuer == "" {
+		issuer = s.IssuerEndpoint
+	}
+	if provider != nil {
+		issuer = provider.IssuerEndpoint
+	}
+	if iss, _ := claims["iss"].(string); issuer != "" && iss != issuer {
+		return nil, fmt.Errorf("id_token issuer (%s) is not valid", iss)
+	}
+
+	// Validate id_token audience, the id_token must be issued for this client
+	if !contains(claimStrings(claims, "aud"), conf.ClientID) {
+		return nil, fmt.Errorf("id_token audience is not valid")
+	}
+
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, fmt.Errorf("id_token is missing the sub claim")
+	}
+
+	return claims, nil
+}
+
+// verifyNonce checks the id_token nonce claim matches the nonce sent in the authorization request.
+func verifyNonce(claims jwt.MapClaims, nonce string) error {
+	claimed, _ := claims["nonce"].(string)
+	if claimed == "" {
+		return fmt.Errorf("id_token is missing the nonce claim")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claimed), []byte(nonce)) != 1 {
+		return fmt.Errorf("id_token nonce does not match")
+	}
+
+	return nil
+}
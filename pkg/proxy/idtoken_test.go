@@ -0,0 +1,30 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestVerifyNonce(t *testing.T) {
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		nonce   string
+		wantErr bool
+	}{
+		{name: "matching nonce", claims: jwt.MapClaims{"nonce": "nonce"}, nonce: "nonce"},
+		{name: "wrong nonce", claims: jwt.MapClaims{"nonce": "other"}, nonce: "nonce", wantErr: true},
+		{name: "missing nonce claim", claims: jwt.MapClaims{}, nonce: "nonce", wantErr: true},
+		{name: "empty nonce claim", claims: jwt.MapClaims{"nonce": ""}, nonce: "", wantErr: true},
+		{name: "nonce claim not a string", claims: jwt.MapClaims{"nonce": 1}, nonce: "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyNonce(tt.claims, tt.nonce); (err != nil) != tt.wantErr {
+				t.Fatalf("verifyNonce() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
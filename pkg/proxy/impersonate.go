@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// errMissingSubject is returned when impersonating users and the token has no sub claim.
+var errMissingSubject = errors.New("token sub claim is required for impersonation")
+
+// removeImpersonationHeaders removes client supplied impersonation headers, requests
+// sent using the operator token must not impersonate users chosen by the client.
+func removeImpersonationHeaders(r *http.Request) {
+	for name := range r.Header {
+		if strings.HasPrefix(name, "Impersonate-") {
+			r.Header.Del(name)
+		}
+	}
+}
+
+// setImpersonationHeaders sets the Impersonate-User header from the token sub claim, and
+// the Impersonate-Group headers from the token groups claim.
+func setImpersonationHeaders(r *http.Request, claims jwt.MapClaims) error {
+	sub, ok := claims["sub"].(string)
+	if !ok || sub == "" {
+		return errMissingSubject
+	}
+
+	r.Header.Set("Impersonate-User", sub)
+	for _, group := range claimStrings(claims, "groups") {
+		r.Header.Add("Impersonate-Group", group)
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultJWKSRefreshInterval is the time JWKS keys are cached before fetching them again.
+	defaultJWKSRefreshInterval = time.Hour
+
+	// jwksMinRefreshInterval limits JWKS fetching when tokens with unknown key ids are received.
+	jwksMinRefreshInterval = 10 * time.Second
+
+	// jwksMaxRetryInterval is the max time to wait before fetching the JWKS again after
+	// failed fetches.
+	jwksMaxRetryInterval = 5 * time.Minute
+
+	// jwksFetchTimeout is the timeout for fetching the JWKS document.
+	jwksFetchTimeout = 10 * time.Second
+)
+
+// jsonWebKey is a public key in a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksCache fetches and caches public keys from a JWKS endpoint by key id. Fetches run
+// outside the lock, and concurrent callers share a single fetch. When a refresh fails the
+// cached keys are still used, and fetching backs off.
+type jwksCache struct {
+	url      string
+	client   *http.Client
+	interval time.Duration
+
+	mu       sync.Mutex
+	keys     map[string]interface{}
+	fetched  time.Time
+	err      error
+	failures int
+	retryAt  time.Time
+	inflight *jwksFetch
+}
+
+// jwksFetch is an in-flight JWKS fetch, err is set when done is closed.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// jwksKeys returns the server JWKS cache.
+func (s *Server) jwksKeys() *jwksCache {
+	s.jwksOnce.Do(func() {
+		s.jwks = s.newJWKSCache(s.JWKSURL)
+	})
+
+	return s.jwks
+}
+
+// newJWKSCache creates an empty cache of the keys of a JWKS endpoint, the keys are
+// fetched on first use.
+func (s *Server) newJWKSCache(url string) *jwksCache {
+	interval := s.JWKSRefreshInterval
+	if interval <= 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+
+	return &jwksCache{
+		url:      url,
+		client:   s.httpClient(jwksFetchTimeout),
+		interval: interval,
+	}
+}
+
+// key returns the public key for a key id. A stale cached key is returned while the JWKS
+// is refreshed in the background, for an unknown key id the caller waits for the fetch.
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.mu.Lock()
+	key, ok := c.lookup(kid)
+	if ok && time.Since(c.fetched) <= c.interval {
+		c.mu.Unlock()
+		return key, nil
+	}
+	call := c.refresh()
+	lastErr := c.err
+	c.mu.Unlock()
+
+	// Use the cached key, the refresh runs in the background
+	if ok {
+		return key, nil
+	}
+
+	if call != nil {
+		<-call.done
+
+		c.mu.Lock()
+		key, ok = c.lookup(kid)
+		c.mu.Unlock()
+		lastErr = call.err
+	}
+	if !ok {
+		if lastErr != nil {
+			return nil, fmt.Errorf("unknown key id (%s): %v", kid, lastErr)
+		}
+		return nil, fmt.Errorf("unknown key id (%s)", kid)
+	}
+
+	return key, nil
+}
+
+// refresh starts fetching the JWKS, and returns the in-flight fetch, or nil when fetching
+// is backing off. The caller must hold the lock.
+func (c *jwksCache) refresh() *jwksFetch {
+	if c.inflight != nil {
+		return c.inflight
+	}
+	if time.Now().Before(c.retryAt) {
+		return nil
+	}
+
+	call := &jwksFetch{done: make(chan struct{})}
+	c.inflight = call
+
+	go func() {
+		keys, err := c.fetch()
+
+		c.mu.Lock()
+		now := time.Now()
+		if err != nil {
+			c.failures++
+			c.retryAt = now.Add(jwksRetryInterval(c.failures))
+		} else {
+			c.keys = keys
+			c.fetched = now
+			c.failures = 0
+			c.retryAt = now.Add(jwksMinRefreshInterval)
+		}
+		c.err = err
+		c.inflight = nil
+		c.mu.Unlock()
+
+		call.err = err
+		close(call.done)
+	}()
+
+	return call
+}
+
+// jwksRetryInterval returns the time to wait before fetching the JWKS again after failures,
+// doubling with each consecutive failure up to jwksMaxRetryInterval.
+func jwksRetryInterval(failures int) time.Duration {
+	interval := jwksMinRefreshInterval
+	for i := 1; i < failures && interval < jwksMaxRetryInterval; i++ {
+		interval *= 2
+	}
+	if interval > jwksMaxRetryInterval {
+		interval = jwksMaxRetryInterval
+	}
+	return interval
+}
+
+// lookup finds a cached key, a token without a key id matches a single key set.
+func (c *jwksCache) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// fetch gets the JWKS document and returns its signing keys by key id.
+func (c *jwksCache) fetch() (map[string]interface{}, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get JWKS: %+v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to get JWKS: %s", resp.Status)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("fail to parse JWKS: %+v", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		// Skip keys not used for signing
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+// publicKey decodes an RSA or EC JSON web key.
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve (%s)", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type (%s)", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
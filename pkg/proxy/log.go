@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// redactedValue replaces sensitive values in log lines.
+const redactedValue = "REDACTED"
+
+// sensitiveQueryParams are query parameters that may hold tokens or codes,
+// and must never be written to the logs.
+var sensitiveQueryParams = []string{"token", "code", "access_token", "refresh_token", "id_token"}
+
+// logger returns the server logger, defaults to the slog default logger.
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// requestAttrs returns the structured log attributes describing a request,
+// the remote address is the client address, resolved using TrustedProxies.
+// The request ID is included when set by RequestIDMiddleware.
+func (s *Server) requestAttrs(r *http.Request) []any {
+	attrs := []any{
+		slog.String("method", r.Method),
+		slog.String("scheme", s.requestScheme(r)),
+		slog.String("path", r.URL.Path),
+		slog.String("query", redactQuery(r.URL.RawQuery)),
+		slog.String("remote_addr", s.clientIP(r)),
+	}
+	if id := RequestIDFromContext(r.Context()); id != "" {
+		attrs = append(attrs, slog.String("request_id", id))
+	}
+
+	return attrs
+}
+
+// startRequestLog wraps the response writer, the returned func logs the request
+// together with the response status, and should be called when the handler returns.
+// The k8s API server response status is logged when the request was proxied.
+func (s *Server) startRequestLog(w http.ResponseWriter, r *http.Request, msg string) (http.ResponseWriter, func()) {
+	rec := &statusRecorder{ResponseWriter: w}
+	attrs := s.requestAttrs(r)
+
+	return rec, func() {
+		attrs = append(attrs, slog.Int("status", rec.Status()))
+		if info := requestInfoFromContext(r.Context()); info != nil && info.upstreamStatus != 0 {
+			attrs = append(attrs, slog.Int("upstream_status", info.upstreamStatus))
+		}
+		s.logger().Info(msg, attrs...)
+	}
+}
+
+// logRequestError logs an error while handling a request.
+func (s *Server) logRequestError(r *http.Request, msg string, err error) {
+	s.logger().Error(msg, append(s.requestAttrs(r), slog.Any("error", err))...)
+}
+
+// redactQuery replaces the values of sensitive query parameters with a redaction marker,
+// a query that can not be parsed is redacted completely.
+func redactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redactedValue
+	}
+
+	for _, name := range sensitiveQueryParams {
+		if _, ok := q[name]; ok {
+			q.Set(name, redactedValue)
+		}
+	}
+
+	return q.Encode()
+}
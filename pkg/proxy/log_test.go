@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawQuery string
+		want     string
+	}{
+		{name: "empty", rawQuery: "", want: ""},
+		{name: "not sensitive", rawQuery: "watch=true", want: "watch=true"},
+		{name: "token", rawQuery: "token=secret&then=%2F", want: "then=%2F&token=REDACTED"},
+		{name: "code", rawQuery: "code=secret&state=abc", want: "code=REDACTED&state=abc"},
+		{name: "access token", rawQuery: "access_token=secret", want: "access_token=REDACTED"},
+		{name: "repeated token", rawQuery: "token=secret&token=other", want: "token=REDACTED"},
+		{name: "malformed", rawQuery: "token=%zz", want: "REDACTED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactQuery(tt.rawQuery); got != tt.want {
+				t.Fatalf("redactQuery(%q) = %q, want %q", tt.rawQuery, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRedaction(t *testing.T) {
+	const secret = "s3cr3t-t0ken"
+
+	tests := []struct {
+		name    string
+		handler func(s *Server) http.Handler
+		target  string
+		header  string
+	}{
+		{
+			name:    "token query",
+			handler: func(s *Server) http.Handler { return http.HandlerFunc(s.Token) },
+			target:  "/token?token=" + secret,
+		},
+		{
+			name:    "access token query",
+			handler: func(s *Server) http.Handler { return s.AuthMiddleware(http.NotFoundHandler()) },
+			target:  "/k8s/api/v1/pods?access_token=" + secret,
+		},
+		{
+			name:    "authorization header",
+			handler: func(s *Server) http.Handler { return s.AuthMiddleware(http.NotFoundHandler()) },
+			target:  "/k8s/api/v1/pods",
+			header:  "Bearer " + secret,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			s := &Server{APIPath: "/k8s/", JWTTokenKey: testJWTKey, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			tt.handler(s).ServeHTTP(httptest.NewRecorder(), r)
+
+			if logs.Len() == 0 {
+				t.Fatalf("no log lines")
+			}
+			if strings.Contains(logs.String(), secret) {
+				t.Fatalf("log lines include the token:\n%s", logs.String())
+			}
+			if tt.header == "" && !strings.Contains(logs.String(), redactedValue) {
+				t.Fatalf("log lines do not include %s:\n%s", redactedValue, logs.String())
+			}
+		})
+	}
+}
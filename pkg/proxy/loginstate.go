@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	// ocgateLoginStateCookieName is the cookie holding the login state id, when using a
+	// LoginStateStore.
+	ocgateLoginStateCookieName = "ocgate-oauth-login"
+
+	// loginStateIDLength is the number of random bytes used for a login state id.
+	loginStateIDLength = 16
+
+	// memoryLoginStateStoreMaxEntries is the number of login states kept by a
+	// MemoryLoginStateStore, the oldest login state is removed when full, so login requests
+	// can not exhaust the server memory.
+	memoryLoginStateStoreMaxEntries = 10000
+)
+
+// LoginState holds the values Login keeps for the OAuth2 callback.
+type LoginState struct {
+	// State is the OAuth2 state sent in the authorization request.
+	State string `json:"state"`
+	// Verifier is the PKCE code verifier, set when UsePKCE is set.
+	Verifier string `json:"verifier,omitempty"`
+	// Nonce is the OpenID Connect nonce, set when UseNonce is set.
+	Nonce string `json:"nonce,omitempty"`
+	// Then is the local page requested before login.
+	Then string `json:"then,omitempty"`
+}
+
+// LoginStateStore keeps the login state on the server during the login flow, when a Server
+// uses a LoginStateStore the state, code verifier and nonce never reach the browser, a single
+// signed cookie holds only a random login state id. External stores (e.g. Redis) implement
+// this interface, Get returns nil and no error for unknown or expired login states.
+type LoginStateStore interface {
+	Get(id string) (*LoginState, error)
+	Set(id string, state *LoginState, ttl time.Duration) error
+	Delete(id string) error
+}
+
+// MemoryLoginStateStore is an in-memory LoginStateStore, login states are not shared between
+// replicas, so login callbacks must reach the replica that served the login request. It keeps
+// up to 10000 login states, the oldest login state is removed when full.
+type MemoryLoginStateStore struct {
+	states *memoryStore[LoginState]
+}
+
+// NewMemoryLoginStateStore creates an empty in-memory login state store.
+func NewMemoryLoginStateStore() *MemoryLoginStateStore {
+	return &MemoryLoginStateStore{states: newMemoryStore[LoginState](memoryLoginStateStoreMaxEntries, nil)}
+}
+
+// Get returns a copy of a login state, or nil if the login state is unknown or expired.
+func (m *MemoryLoginStateStore) Get(id string) (*LoginState, error) {
+	return m.states.get(id), nil
+}
+
+// Set stores a copy of a login state until ttl passes, expired login states are removed.
+func (m *MemoryLoginStateStore) Set(id string, state *LoginState, ttl time.Duration) error {
+	m.states.set(id, state, ttl)
+	return nil
+}
+
+// Delete removes a login state.
+func (m *MemoryLoginStateStore) Delete(id string) error {
+	m.states.delete(id)
+	return nil
+}
+
+// setLoginState keeps the login state for the callback, in the LoginStateStore when set,
+// and in signed short lived cookies otherwise.
+func (s *Server) setLoginState(w http.ResponseWriter, r *http.Request, login *LoginState) error {
+	if s.LoginStateStore == nil {
+		s.setLoginCookie(w, r, s.loginCookieName(ocgateStateCookieName), login.State)
+		if login.Verifier != "" {
+			s.setLoginCookie(w, r, s.loginCookieName(ocgateVerifierCookieName), login.Verifier)
+		}
+		if login.Nonce != "" {
+			s.setLoginCookie(w, r, s.loginCookieName(ocgateNonceCookieName), login.Nonce)
+		}
+		if login.Then != "" {
+			s.setLoginCookie(w, r, s.loginCookieName(ocgateThenCookieName), login.Then)
+		} else {
+			s.clearLoginCookie(w, r, s.loginCookieName(ocgateThenCookieName))
+		}
+		return nil
+	}
+
+	id, err := randomString(loginStateIDLength)
+	if err != nil {
+		return fmt.Errorf("fail to generate login state id: %+v", err)
+	}
+	if err := s.LoginStateStore.Set(id, login, stateCookieMaxAge*time.Second); err != nil {
+		return fmt.Errorf("fail to store login state: %+v", err)
+	}
+	s.setLoginCookie(w, r, s.loginCookieName(ocgateLoginStateCookieName), id)
+
+	return nil
+}
+
+// loginState returns the login state kept by Login, the code verifier and nonce are required
+// when UsePKCE and UseNonce are set.
+func (s *Server) loginState(r *http.Request) (*LoginState, error) {
+	if s.LoginStateStore == nil {
+		return s.loginStateCookies(r)
+	}
+
+	id, err := s.readLoginCookie(r, s.loginCookieName(ocgateLoginStateCookieName))
+	if err != nil {
+		return nil, err
+	}
+	login, err := s.LoginStateStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get login state: %+v", err)
+	}
+	if login == nil {
+		return nil, fmt.Errorf("unknown or expired login state")
+	}
+
+	switch {
+	case s.UsePKCE && login.Verifier == "":
+		return nil, fmt.Errorf("missing code verifier in login state")
+	case s.UseNonce && login.Nonce == "":
+		return nil, fmt.Errorf("missing nonce in login state")
+	}
+
+	return login, nil
+}
+
+// loginStateCookies reads the login state from the signed login cookies.
+func (s *Server) loginStateCookies(r *http.Request) (*LoginState, error) {
+	login := &LoginState{}
+
+	var err error
+	if login.State, err = s.readLoginCookie(r, s.loginCookieName(ocgateStateCookieName)); err != nil {
+		return nil, err
+	}
+	if s.UsePKCE {
+		if login.Verifier, err = s.readLoginCookie(r, s.loginCookieName(ocgateVerifierCookieName)); err != nil {
+			return nil, err
+		}
+	}
+	if s.UseNonce {
+		if login.Nonce, err = s.readLoginCookie(r, s.loginCookieName(ocgateNonceCookieName)); err != nil {
+			return nil, err
+		}
+	}
+	login.Then, _ = s.readLoginCookie(r, s.loginCookieName(ocgateThenCookieName))
+
+	return login, nil
+}
+
+// clearLoginState removes the login state, so it is used by a single callback.
+func (s *Server) clearLoginState(w http.ResponseWriter, r *http.Request) {
+	if s.LoginStateStore == nil {
+		for _, name := range []string{ocgateStateCookieName, ocgateVerifierCookieName, ocgateNonceCookieName, ocgateThenCookieName} {
+			s.clearLoginCookie(w, r, s.loginCookieName(name))
+		}
+		return
+	}
+
+	if id, err := s.readLoginCookie(r, s.loginCookieName(ocgateLoginStateCookieName)); err == nil {
+		if err := s.LoginStateStore.Delete(id); err != nil {
+			s.logRequestError(r, "fail to delete login state", err)
+		}
+	}
+	s.clearLoginCookie(w, r, s.loginCookieName(ocgateLoginStateCookieName))
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Logout clears the session cookies, revokes the session tokens and redirects to the login endpoint.
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	// Log request
+	w, done := s.startRequestLog(w, r, "logout")
+	defer done()
+
+	// Get request token from Authorization header and session cookie
+	token, _ := s.GetRequestToken(r)
+
+	// Revoke tokens using the identity provider that issued them
+	oauthToken, _ := s.sessionOAuthToken(r)
+	issued := token
+	if oauthToken != nil && oauthToken.AccessToken != "" {
+		issued = oauthToken.AccessToken
+	}
+	endpoint, conf := s.RevocationEndpoint, s.Auth2Config
+	if provider := s.tokenProvider(issued); provider != nil {
+		endpoint, conf = provider.RevocationEndpoint, provider.Auth2Config
+	}
+
+	if endpoint != "" {
+		if token != "" {
+			if err := s.revokeToken(endpoint, conf, token, "access_token"); err != nil {
+				s.logRequestError(r, "fail to revoke access token", err)
+			}
+		}
+
+		if oauthToken != nil && oauthToken.RefreshToken != "" {
+			if err := s.revokeToken(endpoint, conf, oauthToken.RefreshToken, "refresh_token"); err != nil {
+				s.logRequestError(r, "fail to revoke refresh token", err)
+			}
+		}
+	}
+
+	// Remove the session and clear session cookies.
+	s.endSession(w, r)
+
+	// Empty redirect, means go to login
+	then := s.PostLogoutRedirect
+	if then == "" {
+		then = s.LoginEndpoint
+	}
+	if then == "" {
+		then = "/"
+	}
+
+	http.Redirect(w, r, then, http.StatusFound)
+}
+
+// revokeToken posts a token revocation request (RFC 7009) to a revocation endpoint,
+// authenticated using the client credentials of the provider that issued the token.
+func (s *Server) revokeToken(endpoint string, conf *oauth2.Config, token string, tokenTypeHint string) error {
+	client := s.oauthHTTPClient()
+
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", tokenTypeHint)
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// Authenticate the client
+	if conf != nil && conf.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(conf.ClientID), url.QueryEscape(conf.ClientSecret))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation endpoint returned %s", resp.Status)
+	}
+
+	return nil
+}
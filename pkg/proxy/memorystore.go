@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// memoryStore is an in-memory map of values that expire, used by the in-memory session
+// and login state stores. Values are kept in insertion order, so expired values are swept
+// from the front of the list, and the oldest value is evicted when the store is full.
+type memoryStore[T any] struct {
+	maxEntries int
+	clone      func(T) T
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// memoryEntry is a stored value and its expiry.
+type memoryEntry[T any] struct {
+	id      string
+	value   T
+	expires time.Time
+}
+
+// newMemoryStore creates an empty in-memory store holding up to maxEntries values, clone
+// if set, copies values that hold pointers, so callers never share a stored value.
+func newMemoryStore[T any](maxEntries int, clone func(T) T) *memoryStore[T] {
+	return &memoryStore[T]{
+		maxEntries: maxEntries,
+		clone:      clone,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// get returns a copy of a value, or nil if the value is unknown or expired.
+func (m *memoryStore[T]) get(id string) *T {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[id]
+	if !ok {
+		return nil
+	}
+	stored := e.Value.(*memoryEntry[T])
+	if time.Now().After(stored.expires) {
+		m.remove(e)
+		return nil
+	}
+
+	value := m.copy(stored.value)
+	return &value
+}
+
+// set stores a copy of a value until ttl passes, expired values are swept, and when the
+// store is full the oldest value is evicted.
+func (m *memoryStore[T]) set(id string, value *T, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.sweep(now)
+
+	if e, ok := m.entries[id]; ok {
+		m.remove(e)
+	}
+	for m.maxEntries > 0 && m.order.Len() >= m.maxEntries {
+		m.remove(m.order.Front())
+	}
+
+	m.entries[id] = m.order.PushBack(&memoryEntry[T]{id: id, value: m.copy(*value), expires: now.Add(ttl)})
+}
+
+// delete removes a value.
+func (m *memoryStore[T]) delete(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[id]; ok {
+		m.remove(e)
+	}
+}
+
+// sweep removes the values expired at now from the front of the list, values stored with
+// a longer ttl stop the sweep, and are removed when read or evicted. The caller must hold
+// the lock.
+func (m *memoryStore[T]) sweep(now time.Time) {
+	for e := m.order.Front(); e != nil && now.After(e.Value.(*memoryEntry[T]).expires); e = m.order.Front() {
+		m.remove(e)
+	}
+}
+
+// remove removes a list element and its map entry, the caller must hold the lock.
+func (m *memoryStore[T]) remove(e *list.Element) {
+	m.order.Remove(e)
+	delete(m.entries, e.Value.(*memoryEntry[T]).id)
+}
+
+// copy returns a copy of a value.
+func (m *memoryStore[T]) copy(value T) T {
+	if m.clone != nil {
+		return m.clone(value)
+	}
+	return value
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "kube_gateway"
+
+// Metrics holds the prometheus collectors used to instrument the proxy server.
+type Metrics struct {
+	gatherer prometheus.Gatherer
+
+	requests       *prometheus.CounterVec
+	proxyLatency   *prometheus.HistogramVec
+	authFailures   *prometheus.CounterVec
+	tokenRefreshes prometheus.Counter
+	jwtFailures    prometheus.Counter
+	breakerState   *prometheus.GaugeVec
+
+	callbackFailures  *prometheus.CounterVec
+	inflight          prometheus.Gauge
+	rejected          prometheus.Counter
+	upstreamResponses *prometheus.CounterVec
+}
+
+// NewMetrics creates the proxy metrics and registers them using the given registerer,
+// if registerer is nil the prometheus default registerer is used.
+func NewMetrics(registerer prometheus.Registerer) (*Metrics, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+
+	m := &Metrics{
+		gatherer: prometheus.DefaultGatherer,
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Total number of proxied requests by method and status code.",
+		}, []string{"method", "code"}),
+		proxyLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "proxy_request_duration_seconds",
+			Help:      "Latency of proxied requests in seconds.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "auth_failures_total",
+			Help:      "Total number of requests rejected by the authentication middleware.",
+		}, []string{"reason"}),
+		tokenRefreshes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "token_refreshes_total",
+			Help:      "Total number of OAuth2 access token refreshes.",
+		}),
+		jwtFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "jwt_validation_failures_total",
+			Help:      "Total number of JWT tokens that failed validation.",
+		}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "circuit_breaker_state",
+			Help:      "State of the upstream circuit breaker, 0 closed, 1 open and 2 half-open.",
+		}, []string{"upstream"}),
+		callbackFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "oauth_callback_failures_total",
+			Help:      "Total number of failed OAuth2 callbacks by reason.",
+		}, []string{"reason"}),
+		inflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "inflight_requests",
+			Help:      "Number of in-flight proxied requests counted by the concurrency limit.",
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "concurrency_limit_rejections_total",
+			Help:      "Total number of requests rejected by the concurrency limit.",
+		}),
+		upstreamResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "upstream_responses_total",
+			Help:      "Total number of k8s API server responses by status code.",
+		}, []string{"code"}),
+	}
+
+	// Use the registerer as gatherer if possible, e.g. a custom prometheus.Registry
+	if gatherer, ok := registerer.(prometheus.Gatherer); ok {
+		m.gatherer = gatherer
+	}
+
+	for _, c := range []prometheus.Collector{m.requests, m.proxyLatency, m.authFailures, m.tokenRefreshes, m.jwtFailures, m.breakerState, m.callbackFailures, m.inflight, m.rejected, m.upstreamResponses} {
+		if err := registerer.Register(c); err != nil {
+			return nil, fmt.Errorf("fail to register metrics: %+v", err)
+		}
+	}
+
+	return m, nil
+}
+
+// MetricsHandler returns a Handler func that exports the server prometheus metrics.
+func (s *Server) MetricsHandler() http.Handler {
+	if s.Metrics == nil {
+		return promhttp.Handler()
+	}
+
+	return promhttp.HandlerFor(s.Metrics.gatherer, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) observeRequest(method string, code int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.requests.WithLabelValues(method, strconv.Itoa(code)).Inc()
+	m.proxyLatency.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+func (m *Metrics) observeUpstream(code int) {
+	if m == nil || code == 0 {
+		return
+	}
+
+	m.upstreamResponses.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+func (m *Metrics) authFailure(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) tokenRefresh() {
+	if m == nil {
+		return
+	}
+
+	m.tokenRefreshes.Inc()
+}
+
+func (m *Metrics) callbackFailure(reason string) {
+	if m == nil {
+		return
+	}
+
+	m.callbackFailures.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) inflightRequests(n int) {
+	if m == nil {
+		return
+	}
+
+	m.inflight.Set(float64(n))
+}
+
+func (m *Metrics) concurrencyRejected() {
+	if m == nil {
+		return
+	}
+
+	m.rejected.Inc()
+}
+
+func (m *Metrics) jwtFailure() {
+	if m == nil {
+		return
+	}
+
+	m.jwtFailures.Inc()
+}
+
+func (m *Metrics) circuitBreakerState(upstream string, state int) {
+	if m == nil {
+		return
+	}
+
+	m.breakerState.WithLabelValues(upstream).Set(float64(state))
+}
+
+// statusRecorder is a ResponseWriter that keeps the response status code, and counts
+// the body bytes written.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush allows streaming responses, e.g. watch requests.
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows upgrade requests, e.g. WebSocket and SPDY.
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Unwrap returns the original ResponseWriter.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Bytes returns the number of body bytes written.
+func (w *statusRecorder) Bytes() int64 {
+	return w.bytes
+}
+
+// Status returns the response status code.
+func (w *statusRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultAPIPath       = "/k8s/"
+	defaultLoginEndpoint = "/auth/login"
+)
+
+// Option configures a Server created by NewServer.
+type Option func(*Server) error
+
+// NewServer creates a proxy Server, and returns an error if the options are not coherent.
+// NewServer is the recommended way to create a Server.
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{
+		APIPath:       defaultAPIPath,
+		LoginEndpoint: defaultLoginEndpoint,
+	}
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// WithAPIServer sets the k8s API server URL and the transport used to reach it.
+func WithAPIServer(apiServerURL string, transport *http.Transport) Option {
+	return func(s *Server) error {
+		if apiServerURL == "" {
+			return fmt.Errorf("missing API server URL")
+		}
+
+		s.APIServerURL = apiServerURL
+		s.APITransport = transport
+		return nil
+	}
+}
+
+// WithAPIPath sets the server endpoint for API calls, defaults to "/k8s/",
+// the path is normalized to start and end with "/".
+func WithAPIPath(apiPath string) Option {
+	return func(s *Server) error {
+		if apiPath == "" {
+			return fmt.Errorf("missing API path")
+		}
+
+		s.APIPath = normalizeAPIPath(apiPath)
+		return nil
+	}
+}
+
+// WithOAuth sets the OAuth2 config and the issuer endpoint used for interactive authentication.
+func WithOAuth(conf *oauth2.Config, issuerEndpoint string) Option {
+	return func(s *Server) error {
+		if conf == nil {
+			return fmt.Errorf("missing OAuth2 config")
+		}
+
+		s.Auth2Config = conf
+		s.IssuerEndpoint = issuerEndpoint
+		return nil
+	}
+}
+
+// WithProvider adds an identity provider users may log in with, selected using the idp
+// login parameter.
+func WithProvider(id string, provider *Provider) Option {
+	return func(s *Server) error {
+		if id == "" || provider == nil || provider.Auth2Config == nil {
+			return fmt.Errorf("identity provider requires an id and an OAuth2 config")
+		}
+
+		if s.Providers == nil {
+			s.Providers = map[string]*Provider{}
+		}
+		s.Providers[id] = provider
+		return nil
+	}
+}
+
+// WithInteractiveAuth enables interactive authentication, requests without a token are
+// redirected to the login endpoint, defaults to "/auth/login".
+func WithInteractiveAuth(loginEndpoint string) Option {
+	return func(s *Server) error {
+		s.InteractiveAuth = true
+		if loginEndpoint != "" {
+			s.LoginEndpoint = loginEndpoint
+		}
+		return nil
+	}
+}
+
+// WithBearerToken sets the operator token that replaces valid JWT tokens for k8s API calls.
+func WithBearerToken(token string) Option {
+	return func(s *Server) error {
+		if token == "" {
+			return fmt.Errorf("missing bearer token")
+		}
+
+		s.BearerToken = token
+		return nil
+	}
+}
+
+// WithBearerTokenPassthrough passes the request token to the k8s API server.
+func WithBearerTokenPassthrough() Option {
+	return func(s *Server) error {
+		s.BearerTokenPassthrough = true
+		return nil
+	}
+}
+
+// WithJWTKey sets the keys used to verify JWT tokens, key is used for HMAC signed
+// tokens and rsaKey for RSA signed tokens.
+func WithJWTKey(key []byte, rsaKey *rsa.PublicKey) Option {
+	return func(s *Server) error {
+		if len(key) == 0 && rsaKey == nil {
+			return fmt.Errorf("missing JWT key")
+		}
+
+		s.JWTTokenKey = key
+		s.JWTTokenRSAKey = rsaKey
+		return nil
+	}
+}
+
+// WithRequestTimeout sets the overall time a request may take, used by RequestTimeoutMiddleware.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(s *Server) error {
+		if timeout < 0 {
+			return fmt.Errorf("request timeout can not be negative")
+		}
+
+		s.RequestTimeout = timeout
+		return nil
+	}
+}
+
+// WithMaxConcurrentRequests sets the in-flight requests limit, used by ConcurrencyLimitMiddleware,
+// and the time a request waits for a slot, zero means fail fast.
+func WithMaxConcurrentRequests(limit int, queueTimeout time.Duration) Option {
+	return func(s *Server) error {
+		if limit < 0 || queueTimeout < 0 {
+			return fmt.Errorf("concurrency limit and queue timeout can not be negative")
+		}
+
+		s.MaxConcurrentRequests = limit
+		s.ConcurrencyQueueTimeout = queueTimeout
+		return nil
+	}
+}
+
+// WithLogger sets the logger used for request and error logging.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) error {
+		s.Logger = logger
+		return nil
+	}
+}
+
+// WithMetrics sets the metrics used to instrument the proxy.
+func WithMetrics(metrics *Metrics) Option {
+	return func(s *Server) error {
+		s.Metrics = metrics
+		return nil
+	}
+}
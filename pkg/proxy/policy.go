@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// errMethodNotPermitted is returned when a token is not allowed to use the request method.
+	errMethodNotPermitted = errors.New("method not permitted for this token")
+	// errPathDenied is returned when a path rule denies the request.
+	errPathDenied = errors.New("path not permitted")
+	// errNotInGroup is returned when the token groups claim has none of the required groups.
+	errNotInGroup = errors.New("user not in an allowed group")
+)
+
+// PolicyRule allows HTTP methods on API paths matching a glob.
+type PolicyRule struct {
+	// Path is a glob (path.Match syntax) matched against the request path relative to
+	// the API path, e.g. "api/v1/namespaces/*/pods", a trailing "/*" matches any sub path.
+	Path string
+	// Methods are the allowed HTTP methods, "*" allows any method.
+	Methods []string
+}
+
+// authorizeMethod checks the request method against the first policy rule matching the
+// API path, and against the JWT methods claim when present.
+func (s *Server) authorizeMethod(claims jwt.MapClaims, method string, requestAPIPath string) error {
+	method = strings.ToUpper(method)
+
+	// Check methods claimed in the token
+	if claimed := claimStrings(claims, "methods"); claimed != nil && !containsMethod(claimed, method) {
+		return errMethodNotPermitted
+	}
+
+	// Check the first matching policy rule
+	for _, rule := range s.Policy {
+		if matchPathGlob(rule.Path, requestAPIPath) {
+			if !containsMethod(rule.Methods, method) {
+				return errMethodNotPermitted
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// matchPathGlob matches a path against a glob, a trailing "/*" matches any sub path.
+func matchPathGlob(glob string, p string) bool {
+	glob = strings.Trim(glob, "/")
+	p = strings.Trim(p, "/")
+
+	if ok, _ := path.Match(glob, p); ok {
+		return true
+	}
+
+	// Check for partial match
+	if strings.HasSuffix(glob, "/*") {
+		prefix := glob[:len(glob)-2]
+		if ok, _ := path.Match(prefix, p); ok {
+			return true
+		}
+
+		parts := strings.Count(prefix, "/") + 1
+		segments := strings.SplitN(p, "/", parts+1)
+		if len(segments) > parts {
+			ok, _ := path.Match(prefix, strings.Join(segments[:parts], "/"))
+			return ok
+		}
+	}
+
+	return false
+}
+
+// PathRule allows or denies requests on API paths matching a regular expression.
+type PathRule struct {
+	// Pattern is a regular expression matched against the request path relative to the
+	// API path, e.g. "^api/v1/namespaces/[^/]+/secrets(/|$)".
+	Pattern string
+	// Methods are the HTTP methods the rule applies to, empty or "*" matches any method.
+	Methods []string
+	// Allow if true allows matching requests, otherwise matching requests are denied.
+	Allow bool
+	// Groups if set, matching requests are denied unless the token groups claim includes
+	// one of the groups.
+	Groups []string
+
+	re *regexp.Regexp
+}
+
+// compilePathRules compiles the path rules patterns.
+func compilePathRules(rules []PathRule) error {
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid path rule pattern (%s): %v", rules[i].Pattern, err)
+		}
+		rules[i].re = re
+	}
+
+	return nil
+}
+
+// authorizePath checks the request against the first matching path rule, requests not
+// matching any rule are allowed, unless PathRulesDefaultDeny is set.
+func (s *Server) authorizePath(claims jwt.MapClaims, method string, requestAPIPath string) error {
+	config := s.current()
+	for _, rule := range config.PathRules {
+		// Fail closed when the server was not validated
+		if rule.re == nil {
+			return fmt.Errorf("path rule (%s) is not compiled", rule.Pattern)
+		}
+		if len(rule.Methods) > 0 && !containsMethod(rule.Methods, method) {
+			continue
+		}
+
+		if rule.re.MatchString(requestAPIPath) {
+			if len(rule.Groups) > 0 && !inGroup(claims, rule.Groups) {
+				return errNotInGroup
+			}
+			if !rule.Allow {
+				return errPathDenied
+			}
+			return nil
+		}
+	}
+
+	if len(config.PathRules) > 0 && config.PathRulesDefaultDeny {
+		return errPathDenied
+	}
+
+	return nil
+}
+
+// authorizeGroups checks the token groups claim includes one of the AllowedGroups.
+func (s *Server) authorizeGroups(claims jwt.MapClaims) error {
+	if groups := s.current().AllowedGroups; len(groups) > 0 && !inGroup(claims, groups) {
+		return errNotInGroup
+	}
+	return nil
+}
+
+// inGroup checks the groups claim, a string or an array of strings, includes one of groups.
+func inGroup(claims jwt.MapClaims, groups []string) bool {
+	for _, group := range claimStrings(claims, "groups") {
+		if contains(groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == "*" || strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestAuthorizeMethod(t *testing.T) {
+	policy := []PolicyRule{
+		{Path: "api/v1/namespaces/*/pods/*", Methods: []string{"GET"}},
+		{Path: "api/v1/*", Methods: []string{"*"}},
+	}
+
+	tests := []struct {
+		name    string
+		policy  []PolicyRule
+		claims  jwt.MapClaims
+		method  string
+		path    string
+		wantErr error
+	}{
+		{name: "no policy", claims: jwt.MapClaims{}, method: "DELETE", path: "api/v1/namespaces/default/pods/web"},
+		{name: "allowed method", policy: policy, claims: jwt.MapClaims{}, method: "get", path: "api/v1/namespaces/default/pods/web"},
+		{name: "denied method", policy: policy, claims: jwt.MapClaims{}, method: "DELETE", path: "api/v1/namespaces/default/pods/web", wantErr: errMethodNotPermitted},
+		{name: "first matching rule", policy: policy, claims: jwt.MapClaims{}, method: "DELETE", path: "api/v1/namespaces/default/secrets/db"},
+		{name: "claimed method", claims: jwt.MapClaims{"methods": []interface{}{"GET", "POST"}}, method: "POST", path: "api/v1/pods"},
+		{name: "not claimed method", claims: jwt.MapClaims{"methods": "GET"}, method: "POST", path: "api/v1/pods", wantErr: errMethodNotPermitted},
+		{name: "claimed method denied by policy", policy: policy, claims: jwt.MapClaims{"methods": []interface{}{"DELETE"}}, method: "DELETE", path: "api/v1/namespaces/default/pods/web", wantErr: errMethodNotPermitted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Policy: tt.policy}
+
+			if err := s.authorizeMethod(tt.claims, tt.method, tt.path); err != tt.wantErr {
+				t.Fatalf("authorizeMethod() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMatchPathGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{glob: "api/v1/namespaces/*/pods", path: "api/v1/namespaces/default/pods", want: true},
+		{glob: "api/v1/namespaces/*/pods", path: "api/v1/namespaces/default/pods/web", want: false},
+		{glob: "/api/v1/namespaces/*/pods/", path: "api/v1/namespaces/default/pods", want: true},
+		{glob: "api/v1/namespaces/*/pods/*", path: "api/v1/namespaces/default/pods", want: true},
+		{glob: "api/v1/namespaces/*/pods/*", path: "api/v1/namespaces/default/pods/web/log", want: true},
+		{glob: "api/v1/namespaces/*/pods/*", path: "api/v1/namespaces/default/secrets", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			if got := matchPathGlob(tt.glob, tt.path); got != tt.want {
+				t.Fatalf("matchPathGlob(%q, %q) = %v, want %v", tt.glob, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizePath(t *testing.T) {
+	secrets := PathRule{Pattern: "^api/v1/namespaces/[^/]+/secrets(/|$)"}
+	readOnlyPods := PathRule{Pattern: "^api/v1/namespaces/[^/]+/pods(/|$)", Methods: []string{"POST", "PUT", "PATCH", "DELETE"}}
+	allowAll := PathRule{Pattern: ".*", Allow: true}
+
+	tests := []struct {
+		name        string
+		rules       []PathRule
+		defaultDeny bool
+		method      string
+		path        string
+		wantErr     error
+	}{
+		{
+			name:   "no rules",
+			method: "GET",
+			path:   "api/v1/namespaces/default/secrets",
+		},
+		{
+			name:   "not matching rule",
+			rules:  []PathRule{secrets},
+			method: "GET",
+			path:   "api/v1/namespaces/default/secretsx",
+		},
+		{
+			name:    "deny rule",
+			rules:   []PathRule{secrets},
+			method:  "GET",
+			path:    "api/v1/namespaces/default/secrets/db",
+			wantErr: errPathDenied,
+		},
+		{
+			name:    "deny rule method",
+			rules:   []PathRule{readOnlyPods, allowAll},
+			method:  "DELETE",
+			path:    "api/v1/namespaces/default/pods/web",
+			wantErr: errPathDenied,
+		},
+		{
+			name:   "deny rule other method",
+			rules:  []PathRule{readOnlyPods, allowAll},
+			method: "GET",
+			path:   "api/v1/namespaces/default/pods/web",
+		},
+		{
+			name:   "first matching rule",
+			rules:  []PathRule{allowAll, readOnlyPods},
+			method: "DELETE",
+			path:   "api/v1/namespaces/default/pods/web",
+		},
+		{
+			name:        "default deny",
+			rules:       []PathRule{readOnlyPods},
+			defaultDeny: true,
+			method:      "GET",
+			path:        "api/v1/namespaces/default/pods",
+			wantErr:     errPathDenied,
+		},
+		{
+			name:        "default deny matching rule",
+			rules:       []PathRule{readOnlyPods, allowAll},
+			defaultDeny: true,
+			method:      "GET",
+			path:        "api/v1/namespaces/default/secrets",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{PathRules: append([]PathRule(nil), tt.rules...), PathRulesDefaultDeny: tt.defaultDeny}
+			if err := compilePathRules(s.PathRules); err != nil {
+				t.Fatalf("compilePathRules() error = %v", err)
+			}
+
+			if err := s.authorizePath(jwt.MapClaims{}, tt.method, tt.path); err != tt.wantErr {
+				t.Fatalf("authorizePath() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompilePathRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{name: "valid", pattern: "^api/v1/namespaces/[^/]+/secrets(/|$)"},
+		{name: "invalid", pattern: "^api/v1/(pods", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := compilePathRules([]PathRule{{Pattern: tt.pattern}}); (err != nil) != tt.wantErr {
+				t.Fatalf("compilePathRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizePathNotCompiled(t *testing.T) {
+	s := &Server{PathRules: []PathRule{{Pattern: ".*", Allow: true}}}
+
+	if err := s.authorizePath(jwt.MapClaims{}, "GET", "api/v1/pods"); err == nil {
+		t.Fatalf("authorizePath() error = nil, want an error for a rule that is not compiled")
+	}
+}
+
+func TestAuthorizeGroups(t *testing.T) {
+	tests := []struct {
+		name          string
+		allowedGroups []string
+		claims        jwt.MapClaims
+		wantErr       error
+	}{
+		{
+			name:   "no allowed groups",
+			claims: jwt.MapClaims{},
+		},
+		{
+			name:          "group array",
+			allowedGroups: []string{"admins", "devs"},
+			claims:        jwt.MapClaims{"groups": []interface{}{"users", "devs"}},
+		},
+		{
+			name:          "group string",
+			allowedGroups: []string{"admins"},
+			claims:        jwt.MapClaims{"groups": "admins"},
+		},
+		{
+			name:          "not in group",
+			allowedGroups: []string{"admins"},
+			claims:        jwt.MapClaims{"groups": []interface{}{"users"}},
+			wantErr:       errNotInGroup,
+		},
+		{
+			name:          "missing groups claim",
+			allowedGroups: []string{"admins"},
+			claims:        jwt.MapClaims{},
+			wantErr:       errNotInGroup,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{AllowedGroups: tt.allowedGroups}
+
+			if err := s.authorizeGroups(tt.claims); err != tt.wantErr {
+				t.Fatalf("authorizeGroups() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizePathGroups(t *testing.T) {
+	rules := []PathRule{{Pattern: "^api/v1/namespaces/[^/]+/secrets(/|$)", Groups: []string{"admins"}, Allow: true}}
+
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		path    string
+		wantErr error
+	}{
+		{name: "in group", claims: jwt.MapClaims{"groups": []interface{}{"users", "admins"}}, path: "api/v1/namespaces/default/secrets/db"},
+		{name: "group string", claims: jwt.MapClaims{"groups": "admins"}, path: "api/v1/namespaces/default/secrets"},
+		{name: "not in group", claims: jwt.MapClaims{"groups": "users"}, path: "api/v1/namespaces/default/secrets", wantErr: errNotInGroup},
+		{name: "missing groups claim", claims: jwt.MapClaims{}, path: "api/v1/namespaces/default/secrets", wantErr: errNotInGroup},
+		{name: "not matching rule", claims: jwt.MapClaims{}, path: "api/v1/namespaces/default/pods"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{PathRules: append([]PathRule(nil), rules...)}
+			if err := compilePathRules(s.PathRules); err != nil {
+				t.Fatalf("compilePathRules() error = %v", err)
+			}
+
+			if err := s.authorizePath(tt.claims, "GET", tt.path); err != tt.wantErr {
+				t.Fatalf("authorizePath() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+// providerStateSeparator separates the provider id from the random part of the OAuth2 state,
+// random strings are base64url encoded and never include it.
+const providerStateSeparator = "."
+
+// Provider is an OAuth2 / OpenID Connect identity provider users may log in with, in addition
+// to the server Auth2Config.
+type Provider struct {
+	// Title is shown on the provider selection page, defaults to the provider id.
+	Title string
+	// Auth2Config is the provider OAuth2 client configuration.
+	Auth2Config *oauth2.Config
+	// IssuerEndpoint is the provider issuer, JWT tokens with a matching iss claim are
+	// validated using the provider keys, and refreshed using the provider Auth2Config.
+	IssuerEndpoint string
+	// JWKSURL is the provider JSON Web Key Set endpoint, used to verify RSA and ECDSA
+	// signed tokens and id_tokens.
+	JWKSURL string
+	// RevocationEndpoint is the provider OAuth2 token revocation (RFC 7009) endpoint,
+	// used on logout to revoke tokens issued by the provider.
+	RevocationEndpoint string
+
+	jwksOnce sync.Once
+	jwks     *jwksCache
+}
+
+// providerLink is a provider login link on the provider selection page.
+type providerLink struct {
+	Title string
+	URL   string
+}
+
+var providerSelectionTemplate = template.Must(template.New("providers").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Login</title></head>
+<body>
+<h1>Login</h1>
+<ul>
+{{range .}}<li><a href="{{.URL}}">{{.Title}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// loginProvider returns the provider selected by the idp login parameter, an empty id
+// selects the server Auth2Config, and a nil provider.
+func (s *Server) loginProvider(id string) (*Provider, *oauth2.Config, error) {
+	if id == "" {
+		if s.Auth2Config == nil {
+			return nil, nil, errOAuthNotConfigured
+		}
+		return nil, s.Auth2Config, nil
+	}
+
+	p, ok := s.Providers[id]
+	if !ok || p.Auth2Config == nil {
+		return nil, nil, fmt.Errorf("unknown identity provider (%s)", id)
+	}
+	return p, p.Auth2Config, nil
+}
+
+// mergeScopes returns the base scopes followed by the additional scopes, without duplicates.
+func mergeScopes(base []string, additional []string) []string {
+	scopes := make([]string, 0, len(base)+len(additional))
+	for _, scope := range append(append([]string{}, base...), additional...) {
+		if scope != "" && !contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// oauthConfigured checks if users can log in using OAuth2.
+func (s *Server) oauthConfigured() bool {
+	return s.Auth2Config != nil || len(s.Providers) > 0
+}
+
+// providerState returns the OAuth2 state of a login, prefixed by the provider id.
+func providerState(id string, state string) string {
+	if id == "" {
+		return state
+	}
+	return id + providerStateSeparator + state
+}
+
+// stateProvider returns the provider id of an OAuth2 state.
+func stateProvider(state string) string {
+	if i := strings.LastIndex(state, providerStateSeparator); i > 0 {
+		return state[:i]
+	}
+	return ""
+}
+
+// tokenProvider returns the provider that issued a JWT token, matched by the unverified
+// iss claim, or nil for tokens validated using the server keys.
+func (s *Server) tokenProvider(token string) *Provider {
+	if len(s.Providers) == 0 {
+		return nil
+	}
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return nil
+	}
+
+	issuer, _ := claims["iss"].(string)
+	if issuer == "" {
+		return nil
+	}
+	for _, p := range s.Providers {
+		if p.IssuerEndpoint != "" && strings.TrimSuffix(p.IssuerEndpoint, "/") == strings.TrimSuffix(issuer, "/") {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// providerTokenKey returns the provider key used to verify a JWT token signature,
+// only RSA and ECDSA signed tokens are accepted.
+func (s *Server) providerTokenKey(p *Provider, t *jwt.Token) (interface{}, error) {
+	switch t.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if p.JWKSURL != "" {
+			kid, _ := t.Header["kid"].(string)
+			return s.providerKeys(p).key(kid)
+		}
+	}
+
+	return nil, fmt.Errorf("%w (%v)", errTokenAlgorithm, t.Header["alg"])
+}
+
+// providerKeys returns the provider JWKS cache.
+func (s *Server) providerKeys(p *Provider) *jwksCache {
+	p.jwksOnce.Do(func() {
+		p.jwks = s.newJWKSCache(p.JWKSURL)
+	})
+
+	return p.jwks
+}
+
+// writeProviderSelection writes a page linking to the login endpoint of each provider.
+func (s *Server) writeProviderSelection(w http.ResponseWriter, r *http.Request) {
+	ids := make([]string, 0, len(s.Providers))
+	for id := range s.Providers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	links := make([]providerLink, 0, len(ids))
+	for _, id := range ids {
+		q := url.Values{"idp": {id}}
+		if then := r.URL.Query().Get("then"); isLocalRedirect(then) {
+			q.Set("then", then)
+		}
+
+		title := s.Providers[id].Title
+		if title == "" {
+			title = id
+		}
+		links = append(links, providerLink{Title: title, URL: r.URL.Path + "?" + q.Encode()})
+	}
+
+	var b bytes.Buffer
+	if err := providerSelectionTemplate.Execute(&b, links); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("fail to render provider selection: %+v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
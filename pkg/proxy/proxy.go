@@ -3,28 +3,148 @@ package proxy
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"html/template"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	jwt "github.com/dgrijalva/jwt-go"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 )
 
 const (
-	ocgateSessionCookieName = "ocgate-session-token"
+	// ocgateCookiePrefix is the prefix of the default cookie names.
+	ocgateCookiePrefix = "ocgate"
+
+	ocgateSessionCookieName  = "ocgate-session-token"
+	ocgateStateCookieName    = "ocgate-oauth-state"
+	ocgateVerifierCookieName = "ocgate-oauth-verifier"
+	ocgateNonceCookieName    = "ocgate-oauth-nonce"
+	ocgateThenCookieName     = "ocgate-oauth-then"
+
+	// defaultPublicPath is the login page served without authentication.
+	defaultPublicPath = "/login.html"
+
+	// defaultStateLength is the number of random bytes used for the OAuth2 state.
+	defaultStateLength = 32
+
+	// pkceVerifierLength is the number of random bytes used for the PKCE code verifier.
+	pkceVerifierLength = 32
+
+	// nonceLength is the number of random bytes used for the OpenID Connect nonce.
+	nonceLength = 32
+
+	// stateCookieMaxAge is the time in seconds a login flow has to complete.
+	stateCookieMaxAge = 300
 )
 
+// errOAuthNotConfigured is returned by the OAuth2 handlers when no identity provider is configured.
+var errOAuthNotConfigured = errors.New("OAuth not configured")
+
 // Server holds information required for serving files.
 type Server struct {
 	APIPath      string
 	APIServerURL string
 	APITransport *http.Transport
-	Auth2Config  *oauth2.Config
+
+	// UpstreamHost overrides the Host header and the TLS server name (SNI) of requests
+	// to the k8s API server, e.g. for API servers behind a shared ingress, defaults to
+	// the APIServerURL host.
+	UpstreamHost string
+
+	// PreserveAPIPath forwards the full request path to the k8s API server, by default
+	// the APIPath prefix is removed, e.g. "/k8s/api/v1/pods" is forwarded as "/api/v1/pods".
+	PreserveAPIPath bool
+
+	Auth2Config *oauth2.Config
+	// Scopes are requested on login in addition to the OAuth2 config scopes, duplicates are
+	// requested once, e.g. "openid", "profile", "email" and "groups" to get a groups claim.
+	Scopes []string
+	// Providers are additional identity providers by id, users select one using the idp
+	// login parameter, e.g. "/auth/login?idp=github", when Auth2Config is not set users
+	// without an idp parameter get a provider selection page.
+	Providers map[string]*Provider
+
+	// Routes maps path prefixes to additional upstream k8s API servers,
+	// served using RoutesProxy, e.g. "/cluster-a/" and "/cluster-b/".
+	Routes map[string]*Route
+
+	// CORS if set, enables cross origin requests from browser clients using CORSMiddleware.
+	CORS *CORSConfig
+
+	// RateLimit if set, limits the request rate of each client using RateLimitMiddleware.
+	RateLimit *RateLimitConfig
+
+	// TrustedProxies is a list of CIDRs of trusted load balancers and proxies, for requests
+	// from a trusted peer the client address and scheme are taken from the X-Forwarded-For
+	// and X-Forwarded-Proto headers.
+	TrustedProxies []string
+
+	// Policy rules limit the HTTP methods JWT tokens may use on API paths,
+	// the first rule matching the request path is applied.
+	Policy []PolicyRule
+
+	// PathRules allow or deny requests of validated tokens by method and API path regular
+	// expression, the first matching rule is applied, the patterns are compiled by Validate.
+	PathRules []PathRule
+	// PathRulesDefaultDeny if true, requests not matching any of the PathRules are denied,
+	// by default they are allowed.
+	PathRulesDefaultDeny bool
+
+	// AllowedGroups if set, validated tokens must have one of the groups in their groups claim.
+	AllowedGroups []string
+
+	// PublicPaths are paths exempt from authentication, entries ending with "/" match
+	// any path with that prefix, other entries match exactly, defaults to ["/login.html"].
+	PublicPaths []string
+
+	// SkipValidationPaths are API paths, relative to the API path (e.g. "/version" or
+	// "/openapi/"), served using the operator token without a request token or JWT checks.
+	// Entries match the path and its sub paths, only GET, HEAD and OPTIONS requests are
+	// exempt. Use for public, read only API endpoints.
+	SkipValidationPaths []string
+
+	// TokenHeaders are the HTTP headers checked for a request token before the session cookie,
+	// e.g. "X-Forwarded-Access-Token", defaults to ["Authorization"]. The Authorization header
+	// must use the Bearer scheme, other headers hold a raw token.
+	TokenHeaders []string
+
+	// SessionCookieName is the name of the session cookie, defaults to "ocgate-session-token".
+	// When set, it is also used as the prefix of the OAuth2 login cookies, e.g. "<name>-oauth-state".
+	SessionCookieName string
+	// CookieSecure if true, cookies are always marked Secure, otherwise cookies are
+	// marked Secure when the request scheme is https.
+	CookieSecure bool
+	// CookieSameSite is the SameSite attribute of the session cookies, defaults to Lax.
+	// Login flow cookies use Lax when Strict is set, so the OAuth2 callback receives them.
+	CookieSameSite http.SameSite
+	// CookieDomain is the Domain attribute of the cookies, defaults to the request host.
+	CookieDomain string
+	// SessionMaxAge is the session cookie lifetime when the token expiry is not known,
+	// e.g. manual logins, zero means the cookie expires when the browser closes.
+	SessionMaxAge time.Duration
+	// BindSessionToClient binds sessions to a hash of the client User-Agent recorded at
+	// login, a session used by another client is removed, and the client must log in again.
+	// Session cookies are bound using CookieSigningKey.
+	BindSessionToClient bool
+	// BindSessionToClientIP also binds sessions to the client IP network (/24 for IPv4, /64
+	// for IPv6), clients changing network, e.g. mobile clients, must log in again.
+	BindSessionToClientIP bool
 
 	BaseAddress    string
 	IssuerEndpoint string
@@ -35,66 +155,510 @@ type Server struct {
 	JWTTokenKey            []byte
 	JWTTokenRSAKey         *rsa.PublicKey
 
+	// BearerTokenFile is a file holding the operator token, e.g. a projected service
+	// account token, the file is read again every BearerTokenReloadInterval so rotated
+	// tokens are used without a restart. When set it overrides BearerToken.
+	BearerTokenFile string
+	// BearerTokenReloadInterval is the time a token read from BearerTokenFile is used,
+	// defaults to 1m.
+	BearerTokenReloadInterval time.Duration
+
+	// UpstreamCAs is the CA pool used to verify the k8s API server certificate,
+	// it overrides the APITransport RootCAs.
+	UpstreamCAs *x509.CertPool
+	// UpstreamCAFile is a PEM encoded CA bundle file, used when UpstreamCAs is not set.
+	UpstreamCAFile string
+	// AllowInsecureUpstream must be set to use an APITransport that skips TLS verification
+	// (InsecureSkipVerify), otherwise Validate returns an error.
+	AllowInsecureUpstream bool
+
+	// ClientCert is a client certificate presented to the k8s API server (mTLS), when
+	// BearerToken is empty requests using a valid JWT token are authenticated using
+	// the certificate only.
+	ClientCert *tls.Certificate
+
+	// ImpersonateUsers if true, requests sent using the operator BearerToken impersonate
+	// the token subject, using the Impersonate-User header set from the JWT sub claim, and
+	// Impersonate-Group headers set from the JWT groups claim. The operator service account
+	// must be allowed to impersonate users and groups.
+	ImpersonateUsers bool
+
 	InteractiveAuth bool
+
+	// ReadOnly rejects mutating requests (e.g. POST, PUT, PATCH and DELETE) to the k8s API
+	// with 405 Method Not Allowed, unless the token methods claim lists the method.
+	ReadOnly bool
+	// AllowedWebSocketOrigins are the origins allowed to send upgrade requests, e.g. exec
+	// WebSocket requests, "*" allows any origin. When empty only the same origin is allowed,
+	// upgrade requests without an Origin header are not browser requests and are allowed.
+	AllowedWebSocketOrigins []string
+
+	// ErrorHTMLTemplate is used to render error pages for requests that prefer text/html,
+	// e.g. browsers, the template data is an ErrorPage. Other requests get a Kubernetes
+	// style Status JSON.
+	ErrorHTMLTemplate *template.Template
+
+	// VerifyIDToken if true, the OpenID Connect id_token returned by the token exchange is
+	// required, and its signature, issuer, audience and expiry are verified on login.
+	VerifyIDToken bool
+
+	// StateLength is the number of random bytes used for the OAuth2 state parameter,
+	// defaults to 32.
+	StateLength int
+	// CookieSigningKey is used to sign short lived login cookies, if empty a random
+	// key is generated for this process, and login cookies set by another replica or
+	// before a restart are not valid.
+	CookieSigningKey []byte
+	// CookieEncryptionKey is used to encrypt the token stored in the session cookie,
+	// if empty the session cookie holds the plain token.
+	CookieEncryptionKey []byte
+	// UsePKCE adds a PKCE (RFC 7636) code challenge to the OAuth2 authorization code flow.
+	UsePKCE bool
+	// UseNonce adds an OpenID Connect nonce to the authorization request, the id_token
+	// returned by the token exchange is verified and must hold the same nonce.
+	UseNonce bool
+	// AccessTypeOffline requests an offline access type on login, needed by some providers,
+	// e.g. Google, to issue refresh tokens.
+	AccessTypeOffline bool
+	// Prompt is the OAuth2 / OpenID Connect prompt parameter of the authorization request,
+	// e.g. "login" or "select_account", defaults to "consent".
+	Prompt string
+	// RefreshThreshold is the remaining access token lifetime that triggers a refresh
+	// using the OAuth2 refresh token, defaults to 60s.
+	RefreshThreshold time.Duration
+
+	// RevocationEndpoint is the OAuth2 token revocation (RFC 7009) endpoint used on logout.
+	RevocationEndpoint string
+	// UserInfoEndpoint is the OpenID Connect userinfo endpoint used by the UserInfo handler,
+	// if empty the user info is read from the token claims.
+	UserInfoEndpoint string
+	// UserInfoCacheTTL is the time a userinfo endpoint response is cached per token, defaults to 5m.
+	UserInfoCacheTTL time.Duration
+	// PostLogoutRedirect is the page to redirect to after logout, defaults to LoginEndpoint.
+	PostLogoutRedirect string
+	// AllowedRedirectHosts are hosts the token endpoint may redirect to, by default
+	// only local paths are allowed.
+	AllowedRedirectHosts []string
+
+	// UpstreamTimeout is the time to wait for the k8s API server response headers,
+	// zero means the default of 30s. Streaming responses are not limited once started.
+	UpstreamTimeout time.Duration
+	// DisableHTTP2 uses HTTP/1.1 for requests to the k8s API server, by default HTTP/2 is
+	// negotiated so concurrent requests, e.g. watches, share a connection. WebSocket and
+	// SPDY upgrade requests, e.g. exec and port-forward, always use HTTP/1.1 connections.
+	DisableHTTP2 bool
+	// OAuthExchangeTimeout is the timeout for token exchange, refresh and revocation
+	// requests to the OAuth2 server, zero means the default of 10s.
+	OAuthExchangeTimeout time.Duration
+	// OAuthHTTPClient if set, is used for requests to the OAuth2 server instead of a client
+	// using APITransport, e.g. to share a connection pool or add instrumentation.
+	OAuthHTTPClient *http.Client
+
+	// RequestTimeout if set, is the overall time a request may take when using
+	// RequestTimeoutMiddleware, watch, log, exec and upgrade requests are not limited.
+	RequestTimeout time.Duration
+
+	// MaxConcurrentRequests limits the number of in-flight proxied requests, used by
+	// ConcurrencyLimitMiddleware, streaming and upgrade requests are not counted, zero
+	// means no limit.
+	MaxConcurrentRequests int
+	// ConcurrencyQueueTimeout is the time a request waits for a slot when the limit is
+	// reached, zero means requests fail fast with 503.
+	ConcurrencyQueueTimeout time.Duration
+
+	// MaxRetries is the number of times idempotent requests (GET, HEAD and OPTIONS) are retried
+	// on upstream connection errors, 503 responses and 429 responses with a Retry-After header,
+	// the upstream Retry-After delay is used when set, zero disables retries.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled on each retry, defaults to 100ms.
+	RetryBackoff time.Duration
+
+	// MaxRequestBodyBytes if set, limits the request body size, larger requests get a 413
+	// response. Watch and upgrade requests are not limited.
+	MaxRequestBodyBytes int64
+
+	// FlushInterval is the flush interval of proxied responses, zero means responses are
+	// flushed when the upstream response is complete, or on each write of chunked responses.
+	// Watch, log and exec requests are always flushed on each write.
+	FlushInterval time.Duration
+
+	// EnableCompression if true, proxied responses are compressed using gzip or deflate
+	// when the client accepts it. Responses smaller than CompressionMinSize, already
+	// encoded responses, upgraded connections and watch or follow requests are not compressed.
+	EnableCompression bool
+	// CompressionMinSize is the minimal response size in bytes compressed, defaults to 1024.
+	CompressionMinSize int
+
+	// AllowedRequestHeaders if set, only the listed request headers are forwarded to the
+	// k8s API server, e.g. "Accept" and "Content-Type". Headers set by the proxy,
+	// Authorization, X-Request-Id and impersonation headers, and headers required by
+	// upgrade requests are always forwarded.
+	AllowedRequestHeaders []string
+
+	// ResponseHeaderModifier if set, is called on each k8s API server response before it is
+	// returned to the client, e.g. to add or remove headers, SecureResponseHeaders adds
+	// security headers. Returning an error fails the request with 502. The body must not
+	// be read, streaming responses are returned while they are written.
+	ResponseHeaderModifier func(*http.Response) error
+
+	// ProxyDirector if set, is called on each proxied request after the path and host are
+	// rewritten to the k8s API server, and sensitive headers are removed, e.g. to add
+	// impersonation headers. The claims of a validated token are available using
+	// ClaimsFromContext(r.Context()).
+	ProxyDirector func(*http.Request)
+
+	// CircuitBreakerThreshold is the number of consecutive upstream failures within
+	// CircuitBreakerWindow that open the circuit, zero disables the circuit breaker.
+	// While open, requests fail fast with 503 for CircuitBreakerCooldown, then a single
+	// request is sent to test if the upstream recovered.
+	CircuitBreakerThreshold int
+	// CircuitBreakerWindow is the time window failures are counted in, defaults to 10s.
+	CircuitBreakerWindow time.Duration
+	// CircuitBreakerCooldown is the time the circuit stays open, defaults to 30s.
+	CircuitBreakerCooldown time.Duration
+
+	// Metrics is used to instrument the proxy, if nil no metrics are collected.
+	Metrics *Metrics
+	// Tracer if set, creates spans for requests, token validation and k8s API server calls,
+	// the trace context is propagated to the k8s API server using the traceparent header.
+	Tracer trace.Tracer
+
+	// JWKSURL is a JSON Web Key Set endpoint, if set the public key used to verify a
+	// JWT token is selected from the key set using the token key id (kid).
+	JWKSURL string
+	// JWKSRefreshInterval is the time JWKS keys are cached, defaults to 1h.
+	JWKSRefreshInterval time.Duration
+
+	// ExpectedAudience if set, JWT tokens must include it in their aud claim.
+	ExpectedAudience string
+
+	// ExpectedIssuer if set, JWT tokens must have a matching iss claim,
+	// defaults to IssuerEndpoint.
+	ExpectedIssuer string
+
+	// ClockSkew is the leeway allowed when validating the JWT exp and nbf claims.
+	ClockSkew time.Duration
+
+	// TokenReviewValidation if true, tokens are authenticated by the k8s API server using
+	// a TokenReview sent with the operator credentials instead of validating JWT tokens,
+	// so opaque tokens are accepted. Requests impersonate the reviewed user, and are
+	// authorized by the k8s API server RBAC, ImpersonateUsers is required.
+	TokenReviewValidation bool
+	// TokenReviewCacheTTL is the time an authenticated token is cached, defaults to 10s.
+	TokenReviewCacheTTL time.Duration
+
+	// ValidationCacheTTL is the time a validated JWT token is cached, repeated requests
+	// with the same token skip validation until the TTL or the token exp claim passes,
+	// zero disables caching.
+	ValidationCacheTTL time.Duration
+	// ValidationCacheSize is the max number of cached tokens, validated and reviewed tokens,
+	// the least recently used tokens are evicted first, defaults to 10000.
+	ValidationCacheSize int
+
+	// Logger is used for request and error logging, defaults to slog.Default().
+	Logger *slog.Logger
+	// AccessLog if set, receives a line for each request, written by AccessLogMiddleware,
+	// used by Run and RunTLS.
+	AccessLog io.Writer
+	// AccessLogFormat is the access log format, AccessLogCombined (default) or AccessLogJSON.
+	AccessLogFormat string
+	// AccessLogRedactUser logs a hash of the token subject instead of the subject.
+	AccessLogRedactUser bool
+
+	// AuditLogger if set, receives every allow and deny decision of the authentication middleware.
+	AuditLogger AuditLogger
+	// OnAuthFailure if set, is called for every request rejected by the authentication
+	// middleware, e.g. to alert on spikes of invalid tokens. The reason is one of the
+	// AuthFailure reasons for token failures, or the rejection reason, e.g. path-denied.
+	OnAuthFailure func(r *http.Request, reason string)
+
+	// RevocationList holds revoked token ids, tokens are checked against it after
+	// validation, defaults to an in-memory list.
+	RevocationList RevocationList
+	// AdminToken is the bearer token required by RevokeHandler requests, if empty
+	// all admin requests are rejected.
+	AdminToken string
+
+	// ServeMux is the handler served by Run and RunTLS, defaults to http.DefaultServeMux.
+	ServeMux *http.ServeMux
+	// ProxyProtocol if true, Run and RunTLS expect every connection to start with a PROXY
+	// protocol v1 or v2 header, e.g. behind an AWS NLB or HAProxy in TCP mode, and use the
+	// client address from the header as the request RemoteAddr.
+	ProxyProtocol bool
+	// ShutdownGracePeriod is the time in-flight requests and upgraded connections have
+	// to complete when Run is cancelled, defaults to 30s.
+	ShutdownGracePeriod time.Duration
+
+	// SessionStore keeps the session tokens on the server, the session cookie then holds
+	// only a session id, by default the tokens are kept in the session cookies.
+	SessionStore SessionStore
+	// LoginStateStore keeps the OAuth2 state, PKCE code verifier and nonce on the server
+	// during login, a login cookie then holds only a login state id, by default they are
+	// kept in signed login cookies.
+	LoginStateStore LoginStateStore
+
+	// ReadyCacheInterval is the time a readiness check result is cached, defaults to 10s.
+	ReadyCacheInterval time.Duration
+
+	jwksOnce sync.Once
+	jwks     *jwksCache
+
+	trustedOnce sync.Once
+	trusted     []*net.IPNet
+
+	revocationOnce sync.Once
+
+	upstreamCAsOnce     sync.Once
+	upstreamCAPool      *x509.CertPool
+	upstreamCAErr       error
+	bearerTokenFileOnce sync.Once
+	tokenFile           *tokenFile
+
+	refreshOnce  sync.Once
+	refreshGroup *refreshGroup
+
+	userInfoOnce  sync.Once
+	userInfoCache *userInfoCache
+
+	tokenReviewOnce       sync.Once
+	tokenReviewHTTPClient *http.Client
+
+	validationOnce  sync.Once
+	validationCache *validationCache
+
+	defaultTransportOnce sync.Once
+	defaultTransport     *http.Transport
+	oauthClientOnce      sync.Once
+	oauthClient          *http.Client
+
+	reloaded atomic.Pointer[Reloadable]
+
+	cookieKeyWarnOnce sync.Once
+
+	accessLogMu sync.Mutex
 }
 
 // Login redirects to OAuth2 authtorization login endpoint.
-func (s Server) Login(w http.ResponseWriter, r *http.Request) {
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
 	// Log request
-	log.Printf("%s %v: %+v", r.RemoteAddr, r.Method, r.URL)
-
-	// Set session cookie.
-	http.SetCookie(w, &http.Cookie{
-		Name:     ocgateSessionCookieName,
-		Value:    "",
-		Path:     "/",
-		SameSite: http.SameSiteLaxMode,
-		HttpOnly: true})
-
-	conf := s.Auth2Config
-	url := conf.AuthCodeURL("sessionID", oauth2.AccessTypeOnline, oauth2.ApprovalForce)
+	w, done := s.startRequestLog(w, r, "login")
+	defer done()
+
+	// Select the identity provider, users choose one when there is no default provider.
+	idp := r.URL.Query().Get("idp")
+	if idp == "" && s.Auth2Config == nil && len(s.Providers) > 0 {
+		s.writeProviderSelection(w, r)
+		return
+	}
+	provider, conf, err := s.loginProvider(idp)
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == errOAuthNotConfigured {
+			code = http.StatusInternalServerError
+		}
+		s.writeError(w, r, code, err)
+		return
+	}
+
+	// Clear session cookie.
+	s.clearSessionCookie(w, r)
+
+	// Generate a random state, used to validate the callback request.
+	stateLength := s.StateLength
+	if stateLength <= 0 {
+		stateLength = defaultStateLength
+	}
+	state, err := randomString(stateLength)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("fail to generate state: %+v", err))
+		return
+	}
+	if provider != nil {
+		state = providerState(idp, state)
+	}
+	login := &LoginState{State: state}
+
+	// Keep the page requested before login, used to redirect back after the callback.
+	if then := r.URL.Query().Get("then"); isLocalRedirect(then) {
+		login.Then = then
+	}
+
+	accessType := oauth2.AccessTypeOnline
+	if s.AccessTypeOffline {
+		accessType = oauth2.AccessTypeOffline
+	}
+	prompt := oauth2.ApprovalForce
+	if s.Prompt != "" {
+		prompt = oauth2.SetAuthURLParam("prompt", s.Prompt)
+	}
+	opts := []oauth2.AuthCodeOption{
+		accessType,
+		prompt,
+		oauth2.SetAuthURLParam("redirect_uri", s.redirectURL(r, conf)),
+	}
+
+	// Add the server scopes to the OAuth2 config scopes.
+	if len(s.Scopes) > 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("scope", strings.Join(mergeScopes(conf.Scopes, s.Scopes), " ")))
+	}
+
+	// Add PKCE code challenge, and keep the code verifier for the callback.
+	if s.UsePKCE {
+		verifier, err := randomString(pkceVerifierLength)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("fail to generate code verifier: %+v", err))
+			return
+		}
+		login.Verifier = verifier
+
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+	}
+
+	// Add OpenID Connect nonce, and keep it for the callback.
+	if s.UseNonce {
+		nonce, err := randomString(nonceLength)
+		if err != nil {
+			s.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("fail to generate nonce: %+v", err))
+			return
+		}
+		login.Nonce = nonce
+
+		opts = append(opts, oauth2.SetAuthURLParam("nonce", nonce))
+	}
+
+	// Keep the state, code verifier and nonce for the callback.
+	if err := s.setLoginState(w, r, login); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	url := conf.AuthCodeURL(state, opts...)
 	http.Redirect(w, r, url, 302)
 }
 
 // Callback handle callbacs from OAuth2 authtorization server.
-func (s Server) Callback(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+func (s *Server) Callback(w http.ResponseWriter, r *http.Request) {
+	// Cancel the token exchange when the client disconnects or the exchange times out
+	ctx, cancel := context.WithTimeout(r.Context(), s.oauthExchangeTimeout())
+	defer cancel()
 
 	// Log request
-	log.Printf("%s %v: %+v", r.RemoteAddr, r.Method, r.URL)
+	w, done := s.startRequestLog(w, r, "callback")
+	defer done()
+
+	if !s.oauthConfigured() {
+		s.writeError(w, r, http.StatusInternalServerError, errOAuthNotConfigured)
+		return
+	}
 
 	q := r.URL.Query()
 	code := q.Get("code")
 
+	// Validate the state received from the OAuth2 server against the state kept by Login.
+	login, err := s.loginState(r)
+	if err == nil {
+		err = validateState(login.State, q.Get("state"))
+	}
+	if err != nil {
+		s.logRequestError(r, "fail authentication", err)
+		s.writeError(w, r, http.StatusForbidden, err)
+		return
+	}
+
+	s.clearLoginState(w, r)
+
+	// Get the identity provider the login started with, the state is validated so the
+	// provider id is the one set by Login.
+	provider, conf, err := s.loginProvider(stateProvider(q.Get("state")))
+	if err != nil {
+		s.logRequestError(r, "fail authentication", err)
+		s.writeError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	// Add PKCE code verifier
+	opts := []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("redirect_uri", s.redirectURL(r, conf))}
+	if s.UsePKCE {
+		opts = append(opts, oauth2.SetAuthURLParam("code_verifier", login.Verifier))
+	}
+
 	// Use the custom HTTP client when requesting a token.
-	httpClient := &http.Client{Transport: s.APITransport, Timeout: 2 * time.Second}
-	ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, s.oauthHTTPClient())
 
-	conf := s.Auth2Config
-	tok, err := conf.Exchange(ctx, code)
+	tok, err := conf.Exchange(ctx, code, opts...)
 	if err != nil {
-		log.Printf("fail authentication: %+v", err)
-		http.Redirect(w, r, s.LoginEndpoint, http.StatusUnauthorized)
+		failure := categorizeExchangeError(err)
+		s.Metrics.callbackFailure(failure.reason)
+		s.logger().Error("fail authentication", append(s.requestAttrs(r),
+			slog.String("reason", failure.reason), slog.String("idp_error", failure.idpError), slog.Any("error", err))...)
+		s.writeError(w, r, failure.code, fmt.Errorf("fail authentication: %s", failure.message))
 		return
 	}
 
-	// Set session cookie.
-	http.SetCookie(w, &http.Cookie{
-		Name:     ocgateSessionCookieName,
-		Value:    tok.AccessToken,
-		Path:     "/",
-		SameSite: http.SameSiteLaxMode,
-		HttpOnly: true})
-	http.Redirect(w, r, "/", http.StatusFound)
+	// Verify the OpenID Connect id_token
+	if s.VerifyIDToken || s.UseNonce {
+		claims, err := s.verifyIDToken(tok, provider, conf)
+		if err == nil && s.UseNonce {
+			err = verifyNonce(claims, login.Nonce)
+		}
+		if err != nil {
+			s.Metrics.callbackFailure("invalid-id-token")
+			s.logRequestError(r, "fail authentication", err)
+			s.writeError(w, r, http.StatusUnauthorized, err)
+			return
+		}
+		s.logger().Info("user authenticated", append(s.requestAttrs(r), slog.Any("sub", claims["sub"]))...)
+	}
+
+	// Start the session, keeping the full token used to refresh the access token.
+	if err := s.startSession(w, r, tok.AccessToken, tok); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("fail to set session: %+v", err))
+		return
+	}
+
+	// Redirect to the page requested before login
+	http.Redirect(w, r, localRedirect(login.Then), http.StatusFound)
+}
+
+// loginURL returns the login endpoint URL, with the requested URI as the then parameter.
+func (s *Server) loginURL(r *http.Request) string {
+	u, err := url.Parse(s.LoginEndpoint)
+	if err != nil {
+		return s.LoginEndpoint
+	}
+
+	q := u.Query()
+	q.Set("then", r.URL.RequestURI())
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// validateState checks that the OAuth2 state matches the state kept by Login.
+func validateState(expected string, state string) error {
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(expected)) != 1 {
+		return fmt.Errorf("oauth state does not match")
+	}
+
+	return nil
+}
+
+// pkceChallenge derives the S256 code challenge from a PKCE code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
 
 // Token handle manual login requests.
-func (s Server) Token(w http.ResponseWriter, r *http.Request) {
+func (s *Server) Token(w http.ResponseWriter, r *http.Request) {
 	var token string
 	var then string
 
 	// Log request
-	log.Printf("%s %v: %+v", r.RemoteAddr, r.Method, r.URL.Path)
+	w, done := s.startRequestLog(w, r, "token")
+	defer done()
 
 	// Get token and redirect from get request
 	if r.Method == http.MethodGet {
@@ -109,138 +673,440 @@ func (s Server) Token(w http.ResponseWriter, r *http.Request) {
 		then = r.FormValue("then")
 	}
 
-	// Empty redirect, means go home
-	if then == "" {
-		then = "/"
-	}
+	// Empty or unsafe redirect, means go home
+	then = s.safeRedirect(then)
 
-	// Set session cookie.
-	http.SetCookie(w, &http.Cookie{
-		Name:     ocgateSessionCookieName,
-		Value:    token,
-		Path:     "/",
-		SameSite: http.SameSiteLaxMode,
-		HttpOnly: true})
+	// Start the session, a manual token can not be refreshed.
+	if err := s.startSession(w, r, token, nil); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, fmt.Errorf("fail to set session: %+v", err))
+		return
+	}
 	http.Redirect(w, r, then, http.StatusFound)
 }
 
 // AuthMiddleware will look for a seesion cookie and use it as a Bearer token.
-func (s Server) AuthMiddleware(next http.Handler) http.Handler {
+func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Log request
-		log.Printf("%s %v: %+v", r.RemoteAddr, r.Method, r.URL)
+		// Log request, together with the k8s API server response status
+		r, _ = withRequestInfo(r)
+		w, done := s.startRequestLog(w, r, "request")
+		defer done()
+
+		// Limit request body size
+		if s.MaxRequestBodyBytes > 0 && !isUpgradeRequest(r) && !isStreamingRequest(r) {
+			if r.ContentLength > s.MaxRequestBodyBytes {
+				s.writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large, limit is %d bytes", s.MaxRequestBodyBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, s.MaxRequestBodyBytes)
+		}
+
+		// Check the origin of upgrade requests
+		// Browsers send the session cookie with cross site WebSocket requests
+		if err := s.checkWebSocketOrigin(r); err != nil {
+			s.forbidden(w, r, nil, "origin-not-allowed", "", err)
+			return
+		}
+
+		// Handle public paths
+		// If the path is exempt from authentication, redirect to next without a token
+		if s.isPublicPath(r.URL.Path) {
+			s.audit(r, nil, AuditAllow, "public-path", nil)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Handle skip validation paths
+		// If the API path is exempt from JWT validation, send the request using the operator token
+		if routePath, bearerToken := s.routeFor(r.URL.Path); !isMutatingMethod(r.Method) &&
+			s.isSkipValidationPath(apiRequestPath(routePath, r.URL.Path)) {
+			s.audit(r, nil, AuditAllow, "skip-validation-path", nil)
+			removeImpersonationHeaders(r)
+			s.removeTokenHeaders(r)
+			if bearerToken != "" {
+				r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearerToken))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Check the session is used by the client that logged in
+		// If the session was recorded for another client, remove it and require a new login
+		if err := s.checkSessionBinding(r); err != nil {
+			s.logRequestError(r, "session client mismatch", err)
+			s.endSession(w, r)
+			if s.InteractiveAuth {
+				s.redirectToLogin(w, r, "session-client-mismatch", err)
+				return
+			}
+			s.unauthorized(w, r, "session-client-mismatch", err)
+			return
+		}
 
 		// Get request token from Authorization header and session cookie
-		token, _ := GetRequestToken(r)
+		token, _ := s.GetRequestToken(r)
+
+		// Handle token refresh
+		// If the session token is about to expire, refresh it using the refresh token
+		if s.InteractiveAuth && s.oauthConfigured() && s.headerToken(r) == "" {
+			refreshed, err := s.refreshToken(r.Context(), w, r)
+			if err != nil {
+				s.logRequestError(r, "fail to refresh token", err)
+			}
+			if refreshed != "" {
+				token = refreshed
+			}
+		}
 
 		// Handle interactive authentication
 		// If no token, redirect to login endpoint
 		if s.InteractiveAuth && token == "" {
-			http.Redirect(w, r, s.LoginEndpoint, http.StatusTemporaryRedirect)
+			s.redirectToLogin(w, r, "no-token", nil)
 			return
 		}
 
 		// Handle non-interactive authentication
 		// If no token, call an error handler
 		if token == "" {
-			handleError(w, fmt.Errorf("no token received"))
+			s.unauthorized(w, r, "no-token", fmt.Errorf("no token received"))
 			return
 		}
 
 		// Handle token pass through
 		// If token exsit, pass to k8s API directly
 		if s.BearerTokenPassthrough {
+			s.audit(r, nil, AuditAllow, "passthrough", nil)
+			s.removeTokenHeaders(r)
 			r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Get requested static and api paths
-		apiPath := strings.Trim(s.APIPath, "/")
-		requestPath := strings.Trim(r.URL.Path, "/")
-		requestAPIPath := ""
-		if len(requestPath) >= len(apiPath) && requestPath[:len(apiPath)] == apiPath {
-			requestAPIPath = requestPath[len(apiPath)+1:]
-		}
+		routePath, bearerToken := s.routeFor(r.URL.Path)
+		requestAPIPath := apiRequestPath(routePath, r.URL.Path)
 
 		// Handle white listed paths
 		// If a static address or API white listed address, redirect to next without validation
 		if requestAPIPath == "" || requestAPIPath == ".well-known/oauth-authorization-server" {
+			s.audit(r, nil, AuditAllow, "whitelisted-path", nil)
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Handle JWT token
 		// Validate API path and token
-		jwtToken, err := authenticateToken(token, s.JWTTokenKey, s.JWTTokenRSAKey)
-		if err != nil || !jwtToken.Valid {
-			handleError(w, err)
+		ctx, span := s.startSpan(r.Context(), "kube-gateway.auth")
+		tokenClaims, err := s.verifyRequestToken(ctx, r, token)
+		endSpan(span, err)
+		if err != nil {
+			s.Metrics.jwtFailure()
+			s.forbidden(w, r, tokenClaims, tokenFailureReason(err), "invalid_token", err)
 			return
 		}
-		if !jwtToken.Valid {
-			handleError(w, fmt.Errorf("JWT token is not valid"))
+
+		// Keep the validated claims, used by hooks and downstream handlers
+		r = r.WithContext(withClaims(r.Context(), tokenClaims))
+
+		// Authorize request method
+		if err := s.authorizeMethod(tokenClaims, r.Method, requestAPIPath); err != nil {
+			s.forbidden(w, r, tokenClaims, "method-not-permitted", "insufficient_scope", err)
 			return
 		}
 
-		// Get token claims
-		tokenClaims, ok := jwtToken.Claims.(jwt.MapClaims)
-		if !ok {
-			handleError(w, fmt.Errorf("JWT token claims are not valid"))
+		// Authorize user groups
+		if err := s.authorizeGroups(tokenClaims); err != nil {
+			s.forbidden(w, r, tokenClaims, "group-not-allowed", "insufficient_scope", err)
+			return
+		}
+
+		// Authorize request path rules
+		if err := s.authorizePath(tokenClaims, r.Method, requestAPIPath); err != nil {
+			reason := "path-denied"
+			if err == errNotInGroup {
+				reason = "group-not-allowed"
+			}
+			s.forbidden(w, r, tokenClaims, reason, "insufficient_scope", err)
 			return
 		}
 
 		// Authorize API path
 		if err := authorizeTokenClamis(tokenClaims, r.Method, requestAPIPath); err != nil {
-			handleError(w, err)
+			s.forbidden(w, r, tokenClaims, "forbidden", "insufficient_scope", err)
 			return
 		}
 
+		// Handle user impersonation
+		// The operator token is used for authentication, and the API server RBAC applies to the token subject
+		removeImpersonationHeaders(r)
+		if s.ImpersonateUsers {
+			if err := setImpersonationHeaders(r, tokenClaims); err != nil {
+				s.forbidden(w, r, tokenClaims, "missing-subject", "invalid_token", err)
+				return
+			}
+		}
+
 		// Handle Valid JWT token
 		// send request using the operator token
-		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.BearerToken))
+		s.audit(r, tokenClaims, AuditAllow, "authorized", nil)
+		s.removeTokenHeaders(r)
+		if bearerToken != "" {
+			r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearerToken))
+		} else {
+			// Authenticate using the client certificate
+			r.Header.Del("Authorization")
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// unauthorized rejects a request without credentials with 401, clients may authenticate
+// and retry the request.
+func (s *Server) unauthorized(w http.ResponseWriter, r *http.Request, reason string, err error) {
+	s.Metrics.authFailure(reason)
+	s.audit(r, nil, AuditDeny, reason, err)
+	s.authFailed(r, reason)
+	setBearerChallenge(w, "", "")
+	s.writeError(w, r, http.StatusUnauthorized, err)
+}
+
+// forbidden rejects a request with credentials that are not valid, or not permitted
+// to make the request, with 403. challengeError is the RFC 6750 error code.
+func (s *Server) forbidden(w http.ResponseWriter, r *http.Request, claims jwt.MapClaims, reason string, challengeError string, err error) {
+	s.Metrics.authFailure(reason)
+	s.audit(r, claims, AuditDeny, reason, err)
+	s.authFailed(r, reason)
+	setBearerChallenge(w, challengeError, err.Error())
+	s.writeError(w, r, http.StatusForbidden, err)
+}
+
 // APIProxy return a Handler func that will proxy request to k8s API.
-func (s Server) APIProxy() http.Handler {
+func (s *Server) APIProxy() http.Handler {
+	return s.newAPIProxy(s.APIPath, s.APIServerURL, s.APITransport, s.UpstreamHost)
+}
+
+// invalidAPIProxy returns a Handler func that fails all requests, it is used
+// when the API server URL can not be parsed.
+func (s *Server) invalidAPIProxy(apiServerURL string, err error) http.Handler {
+	err = fmt.Errorf("invalid API server URL (%s): %v", apiServerURL, err)
+	s.logger().Error("fail to create API proxy", "upstream", apiServerURL, "error", err)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.writeError(w, r, http.StatusInternalServerError, err)
+	})
+}
+
+// newAPIProxy return a Handler func that will proxy requests under apiPath to a k8s API server.
+// The Host header of forwarded requests is upstreamHost, or the API server URL host.
+func (s *Server) newAPIProxy(apiPath string, apiServerURL string, apiTransport *http.Transport, upstreamHost string) http.Handler {
 	// Parse the url
-	url, _ := url.Parse(s.APIServerURL)
+	if err := validateServerURL(apiServerURL); err != nil {
+		return s.invalidAPIProxy(apiServerURL, err)
+	}
+	url, _ := url.Parse(apiServerURL)
 
 	// Create the reverse proxy
 	proxy := httputil.NewSingleHostReverseProxy(url)
-	proxy.Transport = s.APITransport
+	proxy.Transport = s.proxyTransport(apiServerURL, apiTransport, upstreamHost)
+	proxy.ErrorHandler = s.proxyErrorHandler
+	director := proxy.Director
+	allowedHeaders := s.allowedHeaderSet()
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		// Do not forward the client Host header, an empty Host uses the URL host
+		r.Host = upstreamHost
+		stripRequestHeaders(r)
+		filterRequestHeaders(r, allowedHeaders)
+		if s.ProxyDirector != nil {
+			s.ProxyDirector(r)
+		}
+	}
+	proxy.ModifyResponse = s.modifyResponse()
+
+	// Streaming requests are flushed on each write
+	proxy.FlushInterval = s.FlushInterval
+	streamingProxy := *proxy
+	streamingProxy.FlushInterval = -1
 
-	return http.HandlerFunc(
+	handler := http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
+			// Check the request is for the API path
+			if !hasAPIPath(r, apiPath) {
+				s.writeError(w, r, http.StatusBadRequest, fmt.Errorf("path %s is not under %s", r.URL.Path, apiPath))
+				return
+			}
+
+			// Check read only mode
+			if err := s.checkReadOnly(r); err != nil {
+				w.Header().Set("Allow", readOnlyAllowHeader)
+				s.writeError(w, r, http.StatusMethodNotAllowed, err)
+				return
+			}
+
 			// Update the headers to allow for SSL redirection
 			r.URL.Host = url.Host
 			r.URL.Scheme = url.Scheme
-			r.URL.Path = r.URL.Path[len(s.APIPath)-1:]
+			if !s.PreserveAPIPath {
+				trimAPIPath(r, apiPath)
+			}
 
 			// Log proxy request
-			log.Printf("%s %v: [PROXY] %+v", r.RemoteAddr, r.Method, r.URL)
+			// Upgrade requests (WebSocket / SPDY) are handled by the reverse proxy,
+			// which keeps the Upgrade headers and copies the streams both ways.
+			attrs := append(s.requestAttrs(r), slog.String("upstream", url.Host))
+			if isUpgradeRequest(r) {
+				attrs = append(attrs, slog.String("upgrade", r.Header.Get("Upgrade")))
+			}
 
 			// Call server
-			proxy.ServeHTTP(w, r)
+			start := time.Now()
+			r, info := withRequestInfo(r)
+			rec := &statusRecorder{ResponseWriter: w}
+			if isStreamingRequest(r) {
+				streamingProxy.ServeHTTP(rec, r)
+			} else {
+				proxy.ServeHTTP(rec, r)
+			}
+			s.Metrics.observeRequest(r.Method, rec.Status(), time.Since(start))
+			s.Metrics.observeUpstream(info.upstreamStatus)
+
+			attrs = append(attrs, slog.Int("status", rec.Status()), slog.Int("upstream_status", info.upstreamStatus), slog.Duration("duration", time.Since(start)))
+			s.logger().Info("proxy", attrs...)
 		})
+
+	// Compress responses
+	if s.EnableCompression {
+		return s.compressHandler(handler)
+	}
+
+	return handler
+}
+
+// isPublicPath checks if a path is exempt from authentication.
+func (s *Server) isPublicPath(path string) bool {
+	publicPaths := s.PublicPaths
+	if publicPaths == nil {
+		publicPaths = []string{defaultPublicPath}
+	}
+
+	for _, p := range publicPaths {
+		if p == path || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
 }
 
-func handleError(w http.ResponseWriter, err error) {
-	w.WriteHeader(http.StatusForbidden)
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, "{\"kind\": \"Status\", \"api\": \"ocgate\", \"status\": \"Forbidden\", \"message\": \"%s\",\"code\": %d}", err, http.StatusForbidden)
+// apiRequestPath returns the request path relative to the API path, without leading
+// and trailing slashes, or an empty string if the path is not under the API path.
+func apiRequestPath(routePath string, path string) string {
+	apiPath := strings.Trim(routePath, "/")
+	requestPath := strings.Trim(path, "/")
+	if len(requestPath) > len(apiPath) && requestPath[:len(apiPath)+1] == apiPath+"/" {
+		return requestPath[len(apiPath)+1:]
+	}
+	return ""
+}
+
+// isSkipValidationPath checks if an API request path is exempt from JWT validation.
+func (s *Server) isSkipValidationPath(requestAPIPath string) bool {
+	if requestAPIPath == "" {
+		return false
+	}
+
+	for _, p := range s.SkipValidationPaths {
+		p = strings.Trim(p, "/")
+		if p != "" && (requestAPIPath == p || strings.HasPrefix(requestAPIPath, p+"/")) {
+			return true
+		}
+	}
+	return false
 }
 
-// GetRequestToken parses a request and get the token to pass to k8s API
+// bearerToken returns the token from the Authorization HTTP header, the Bearer scheme
+// is matched case insensitive, and surrounding whitespace is ignored.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// tokenHeaders returns the names of the HTTP headers that may carry the request token.
+func (s *Server) tokenHeaders() []string {
+	if len(s.TokenHeaders) > 0 {
+		return s.TokenHeaders
+	}
+	return []string{"Authorization"}
+}
+
+// headerToken returns the token from the first token header set on the request,
+// the Authorization header must use the Bearer scheme, other headers hold a raw token.
+func (s *Server) headerToken(r *http.Request) string {
+	for _, name := range s.tokenHeaders() {
+		if http.CanonicalHeaderKey(name) == "Authorization" {
+			if token, ok := bearerToken(r); ok {
+				return token
+			}
+			continue
+		}
+
+		if token := strings.TrimSpace(r.Header.Get(name)); token != "" {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// removeTokenHeaders removes the token headers and the WebSocket token subprotocol,
+// so the request token is only sent to the k8s API in the Authorization header.
+func (s *Server) removeTokenHeaders(r *http.Request) {
+	for _, name := range s.tokenHeaders() {
+		r.Header.Del(name)
+	}
+	removeWebSocketToken(r)
+}
+
+// GetRequestToken parses a request and get the token to pass to k8s API,
+// using the default session cookie name.
 func GetRequestToken(r *http.Request) (string, error) {
+	return getRequestToken(r, ocgateSessionCookieName)
+}
+
+// GetRequestToken parses a request and get the token to pass to k8s API,
+// using the server token headers, session cookie name and encryption key.
+// A session cookie that can not be decrypted is treated as no token.
+func (s *Server) GetRequestToken(r *http.Request) (string, error) {
+	// Check for token HTTP headers
+	if token := s.headerToken(r); token != "" {
+		return token, nil
+	}
+
+	// Check for a WebSocket token subprotocol
+	if token := webSocketToken(r); token != "" {
+		return token, nil
+	}
+
+	// Check for session
+	token, err := s.sessionToken(r)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func getRequestToken(r *http.Request, cookieName string) (string, error) {
 	// Check for Authorization HTTP header
-	if authorization := r.Header.Get("Authorization"); len(authorization) > 7 && authorization[:7] == "Bearer " {
-		return authorization[7:], nil
+	if token, ok := bearerToken(r); ok {
+		return token, nil
 	}
 
 	// Check for session cookie
-	cookie, err := r.Cookie(ocgateSessionCookieName)
+	cookie, err := r.Cookie(cookieName)
 	if err != nil || cookie.Value == "" {
 		return "", err
 	}
@@ -0,0 +1,352 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+func TestAPIProxyPathOutsideAPIPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		apiPath    string
+		path       string
+		wantStatus int
+	}{
+		{name: "shorter than API path", apiPath: "/api/", path: "/a", wantStatus: http.StatusBadRequest},
+		{name: "root", apiPath: "/api/", path: "/", wantStatus: http.StatusBadRequest},
+		{name: "API path prefix of another path", apiPath: "/api/", path: "/apis/v1", wantStatus: http.StatusBadRequest},
+		{name: "API path without trailing slash", apiPath: "/api/", path: "/api", wantStatus: http.StatusOK},
+		{name: "under API path", apiPath: "/api/", path: "/api/v1/pods", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIPath: tt.apiPath, APIServerURL: upstream.URL}
+
+			w := httptest.NewRecorder()
+			s.APIProxy().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestNormalizeAPIPath(t *testing.T) {
+	tests := []struct {
+		apiPath string
+		want    string
+	}{
+		{apiPath: "/api", want: "/api/"},
+		{apiPath: "/api/", want: "/api/"},
+		{apiPath: "api/", want: "/api/"},
+		{apiPath: "api", want: "/api/"},
+		{apiPath: "/k8s/api/", want: "/k8s/api/"},
+		{apiPath: "/", want: "/"},
+		{apiPath: "", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.apiPath, func(t *testing.T) {
+			if got := normalizeAPIPath(tt.apiPath); got != tt.want {
+				t.Fatalf("normalizeAPIPath(%q) = %q, want %q", tt.apiPath, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIProxyAPIPathRewrite(t *testing.T) {
+	paths := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.RequestURI()
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name     string
+		apiPath  string
+		path     string
+		wantPath string
+	}{
+		{name: "leading slash", apiPath: "/api", path: "/api/v1/pods", wantPath: "/v1/pods"},
+		{name: "leading and trailing slash", apiPath: "/api/", path: "/api/v1/pods", wantPath: "/v1/pods"},
+		{name: "trailing slash", apiPath: "api/", path: "/api/v1/pods", wantPath: "/v1/pods"},
+		{name: "query", apiPath: "/api", path: "/api/v1/pods?watch=1", wantPath: "/v1/pods?watch=1"},
+		{name: "API path itself", apiPath: "/api", path: "/api", wantPath: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIPath: tt.apiPath, APIServerURL: upstream.URL}
+
+			w := httptest.NewRecorder()
+			s.APIProxy().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := <-paths; got != tt.wantPath {
+				t.Fatalf("upstream path = %s, want %s", got, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestIsPublicPath(t *testing.T) {
+	tests := []struct {
+		name        string
+		publicPaths []string
+		path        string
+		want        bool
+	}{
+		{name: "default", path: "/login.html", want: true},
+		{name: "default not matching", path: "/login.html/x", want: false},
+		{name: "default not prefix", path: "/login", want: false},
+		{name: "exact", publicPaths: []string{"/signin.html"}, path: "/signin.html", want: true},
+		{name: "exact replaces default", publicPaths: []string{"/signin.html"}, path: "/login.html", want: false},
+		{name: "exact not prefix", publicPaths: []string{"/static"}, path: "/static/app.js", want: false},
+		{name: "prefix", publicPaths: []string{"/static/"}, path: "/static/app.js", want: true},
+		{name: "prefix itself", publicPaths: []string{"/static/"}, path: "/static/", want: true},
+		{name: "prefix not matching", publicPaths: []string{"/static/"}, path: "/staticx/app.js", want: false},
+		{name: "empty list", publicPaths: []string{}, path: "/login.html", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{PublicPaths: tt.publicPaths}
+
+			if got := s.isPublicPath(tt.path); got != tt.want {
+				t.Fatalf("isPublicPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewarePublicPaths(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/static/app.js", wantStatus: http.StatusOK},
+		{path: "/k8s/api/v1/pods", wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			s := &Server{APIPath: "/k8s/", JWTTokenKey: testJWTKey, PublicPaths: []string{"/static/"}}
+
+			w := httptest.NewRecorder()
+			s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCallbackExchangeCancel(t *testing.T) {
+	release := make(chan struct{})
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer tokenServer.Close()
+	defer close(release)
+
+	tests := []struct {
+		name            string
+		exchangeTimeout time.Duration
+		cancelAfter     time.Duration
+	}{
+		{name: "client disconnects", exchangeTimeout: time.Minute, cancelAfter: 50 * time.Millisecond},
+		{name: "exchange timeout", exchangeTimeout: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				Auth2Config:          &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL}, RedirectURL: "/callback"},
+				OAuthExchangeTimeout: tt.exchangeTimeout,
+			}
+
+			// Keep the login state cookies for the callback
+			login := httptest.NewRecorder()
+			if err := s.setLoginState(login, httptest.NewRequest(http.MethodGet, "/login", nil), &LoginState{State: "state"}); err != nil {
+				t.Fatalf("setLoginState() error = %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/callback?code=code&state=state", nil).WithContext(ctx)
+			for _, c := range login.Result().Cookies() {
+				r.AddCookie(c)
+			}
+
+			done := make(chan int)
+			go func() {
+				w := httptest.NewRecorder()
+				s.Callback(w, r)
+				done <- w.Code
+			}()
+
+			select {
+			case code := <-done:
+				if code < http.StatusBadRequest {
+					t.Fatalf("status = %d, want an error status", code)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("callback did not return after the exchange was cancelled")
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareTokenStatus(t *testing.T) {
+	claims := jwt.MapClaims{"sub": "user", "apiGroups": []interface{}{"*"}}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+	}{
+		{name: "no token", wantStatus: http.StatusUnauthorized},
+		{name: "invalid token", token: "invalid", wantStatus: http.StatusForbidden},
+		{name: "token signed with another key", token: signHS256(t, []byte("other-key"), claims), wantStatus: http.StatusForbidden},
+		{name: "valid token", token: signHS256(t, testJWTKey, claims), wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIPath: "/k8s/", JWTTokenKey: testJWTKey}
+
+			r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestGetRequestToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		cookie        string
+		want          string
+	}{
+		{name: "bearer", authorization: "Bearer token", want: "token"},
+		{name: "lowercase bearer", authorization: "bearer token", want: "token"},
+		{name: "uppercase bearer", authorization: "BEARER token", want: "token"},
+		{name: "double space", authorization: "Bearer  token", want: "token"},
+		{name: "surrounding whitespace", authorization: " Bearer token ", want: "token"},
+		{name: "empty token", authorization: "Bearer ", want: ""},
+		{name: "empty token with spaces", authorization: "Bearer    ", want: ""},
+		{name: "empty token falls back to cookie", authorization: "Bearer ", cookie: "cookie-token", want: "cookie-token"},
+		{name: "other scheme", authorization: "Basic dXNlcjpwYXNz", want: ""},
+		{name: "lowercase bearer before cookie", authorization: "bearer token", cookie: "cookie-token", want: "token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+			r.Header.Set("Authorization", tt.authorization)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: ocgateSessionCookieName, Value: tt.cookie})
+			}
+
+			got, _ := GetRequestToken(r)
+			if got != tt.want {
+				t.Fatalf("GetRequestToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsSkipValidationPath(t *testing.T) {
+	tests := []struct {
+		name                string
+		skipValidationPaths []string
+		path                string
+		want                bool
+	}{
+		{name: "no skip paths", path: "version", want: false},
+		{name: "exact", skipValidationPaths: []string{"version"}, path: "version", want: true},
+		{name: "slashes", skipValidationPaths: []string{"/openapi/"}, path: "openapi", want: true},
+		{name: "prefix", skipValidationPaths: []string{"openapi"}, path: "openapi/v2", want: true},
+		{name: "not a path prefix", skipValidationPaths: []string{"openapi"}, path: "openapiv2", want: false},
+		{name: "not matching", skipValidationPaths: []string{"version"}, path: "api/v1/pods", want: false},
+		{name: "empty skip path", skipValidationPaths: []string{"/"}, path: "api/v1/pods", want: false},
+		{name: "empty path", skipValidationPaths: []string{"version"}, path: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{SkipValidationPaths: tt.skipValidationPaths}
+
+			if got := s.isSkipValidationPath(tt.path); got != tt.want {
+				t.Fatalf("isSkipValidationPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareSkipValidationPaths(t *testing.T) {
+	tests := []struct {
+		name              string
+		method            string
+		path              string
+		wantStatus        int
+		wantAuthorization string
+	}{
+		{name: "skip path under API path", method: http.MethodGet, path: "/k8s/version", wantStatus: http.StatusOK, wantAuthorization: "Bearer operator-token"},
+		{name: "skip path prefix", method: http.MethodGet, path: "/k8s/openapi/v2", wantStatus: http.StatusOK, wantAuthorization: "Bearer operator-token"},
+		{name: "mutating method", method: http.MethodPost, path: "/k8s/version", wantStatus: http.StatusForbidden},
+		{name: "path not in skip list", method: http.MethodGet, path: "/k8s/api/v1/pods", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIPath: "/k8s/", BearerToken: "operator-token", JWTTokenKey: testJWTKey, SkipValidationPaths: []string{"version", "openapi"}}
+
+			var authorization string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+			})
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Authorization", "Bearer client-token")
+			w := httptest.NewRecorder()
+			s.AuthMiddleware(next).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if authorization != tt.wantAuthorization {
+				t.Fatalf("forwarded Authorization = %q, want %q", authorization, tt.wantAuthorization)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyProtocolHeaderTimeout is the time a client has to send the PROXY protocol header.
+	proxyProtocolHeaderTimeout = 10 * time.Second
+
+	// proxyProtocolV1MaxLength is the maximum length of a PROXY protocol v1 header line.
+	proxyProtocolV1MaxLength = 107
+)
+
+// proxyProtocolV2Signature starts a PROXY protocol v2 header.
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolListener accepts connections that start with a PROXY protocol v1 or v2
+// header, e.g. from an AWS NLB or HAProxy in TCP mode, and reports the client address
+// from the header as the connection remote address.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+// Accept waits for a connection, the PROXY protocol header is read by the connection
+// goroutine, so a slow client does not block the accept loop.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{Conn: c}, nil
+}
+
+// proxyProtocolConn reads the PROXY protocol header on the first Read or RemoteAddr call.
+type proxyProtocolConn struct {
+	net.Conn
+
+	once   sync.Once
+	r      *bufio.Reader
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.r = bufio.NewReader(c.Conn)
+
+		c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+		c.remote, c.err = readProxyProtocolHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		if c.err != nil {
+			c.err = fmt.Errorf("fail to read PROXY protocol header: %+v", c.err)
+		}
+	})
+}
+
+// Read reads data following the PROXY protocol header.
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY protocol header, or the
+// connection remote address for LOCAL and UNKNOWN headers, e.g. health checks.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyProtocolHeader reads a PROXY protocol v1 or v2 header, and returns the source
+// address, or nil if the header does not carry a TCP source address.
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	if sig, err := r.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(sig, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	}
+
+	if prefix, err := r.Peek(6); err == nil && string(prefix) == "PROXY " {
+		return readProxyProtocolV1(r)
+	}
+
+	return nil, fmt.Errorf("missing PROXY protocol header")
+}
+
+// readProxyProtocolV1 reads a text header, e.g. "PROXY TCP4 192.0.2.1 192.0.2.2 51234 443\r\n".
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) > proxyProtocolV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("invalid v1 header")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid v1 source address")
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2 reads a binary header, only TCP over IPv4 and IPv6 source
+// addresses are used.
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 || command > 1 {
+		return nil, fmt.Errorf("invalid v2 version or command")
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections, e.g. load balancer health checks, use the connection address
+	if command == 0 {
+		return nil, nil
+	}
+
+	family, transport := header[13]>>4, header[13]&0x0f
+	if transport != 1 {
+		return nil, nil
+	}
+
+	switch {
+	case family == 1 && len(payload) >= 12:
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case family == 2 && len(payload) >= 36:
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	case family == 1 || family == 2:
+		return nil, fmt.Errorf("invalid v2 address length")
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,85 @@
+package proxytest_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/yaacov/kube-gateway/pkg/proxy"
+	"github.com/yaacov/kube-gateway/pkg/proxy/proxytest"
+)
+
+const maxRequestBodyBytes = 16
+
+// withMaxRequestBodyBytes limits the request body size to maxRequestBodyBytes.
+func withMaxRequestBodyBytes(s *proxy.Server) error {
+	s.MaxRequestBodyBytes = maxRequestBodyBytes
+	return nil
+}
+
+// readBodyHandler returns a fake k8s API handler, reading the full request body
+// before responding.
+func readBodyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+}
+
+func TestMaxRequestBodyBytes(t *testing.T) {
+	small := strings.Repeat("x", maxRequestBodyBytes)
+	large := strings.Repeat("x", maxRequestBodyBytes+1)
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		chunked    bool
+		wantStatus int
+	}{
+		{name: "body at the limit", path: "api/v1/namespaces/default/configmaps", body: small, wantStatus: http.StatusOK},
+		{name: "body over the limit", path: "api/v1/namespaces/default/configmaps", body: large, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "chunked body over the limit", path: "api/v1/namespaces/default/configmaps", body: large, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "watch request is exempt", path: "api/v1/namespaces/default/configmaps?watch=true", body: large, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := proxytest.New(t, readBodyHandler(), withMaxRequestBodyBytes)
+
+			var body io.Reader = strings.NewReader(tt.body)
+			if tt.chunked {
+				// Hide the body length, the request is sent without a Content-Length
+				body = io.MultiReader(body)
+			}
+			req, _ := http.NewRequest(http.MethodPost, env.Gateway.URL+proxytest.APIPath+tt.path, body)
+			req.Header.Set("Authorization", "Bearer "+env.Token(nil))
+			resp, err := env.Client().Do(req)
+			if err != nil {
+				t.Fatalf("fail to call API: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusRequestEntityTooLarge {
+				return
+			}
+
+			var status struct {
+				Kind string `json:"kind"`
+				Code int    `json:"code"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+				t.Fatalf("fail to decode Status body: %v", err)
+			}
+			if status.Kind != "Status" || status.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("body kind = %q code = %d, want a Status with code %d", status.Kind, status.Code, http.StatusRequestEntityTooLarge)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package proxytest_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/yaacov/kube-gateway/pkg/proxy"
+	"github.com/yaacov/kube-gateway/pkg/proxy/proxytest"
+)
+
+// startLogin starts a login using client, and returns the callback URL the OAuth2
+// server redirects to, including the code and state.
+func startLogin(t *testing.T, env *proxytest.Env, client *http.Client) *url.URL {
+	t.Helper()
+
+	resp, err := client.Get(env.Gateway.URL + proxytest.LoginEndpoint)
+	if err != nil {
+		t.Fatalf("fail to login: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	resp, err = client.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("fail to authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("fail to parse callback URL: %v", err)
+	}
+	return callback
+}
+
+// withQuery returns a copy of u with a query parameter replaced.
+func withQuery(u *url.URL, key string, value string) *url.URL {
+	c := *u
+	q := c.Query()
+	q.Set(key, value)
+	c.RawQuery = q.Encode()
+	return &c
+}
+
+// callback requests a callback URL using client, and returns the response status.
+func callback(t *testing.T, client *http.Client, u *url.URL) int {
+	t.Helper()
+
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatalf("fail to call callback: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// apiStatus requests the API using client, and returns the response status.
+func apiStatus(t *testing.T, env *proxytest.Env, client *http.Client) int {
+	t.Helper()
+
+	resp, err := client.Get(env.Gateway.URL + proxytest.APIPath + "api/v1/pods")
+	if err != nil {
+		t.Fatalf("fail to call API: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestCallbackState(t *testing.T) {
+	tests := []struct {
+		name string
+		// callback returns the callback URL the client requests, login is the client
+		// login callback URL, other is the callback URL of a login by another client.
+		callback   func(login *url.URL, other *url.URL) *url.URL
+		wantStatus int
+	}{
+		{
+			name:       "valid",
+			callback:   func(login *url.URL, other *url.URL) *url.URL { return login },
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "wrong state",
+			callback:   func(login *url.URL, other *url.URL) *url.URL { return withQuery(login, "state", "wrong") },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "missing state",
+			callback:   func(login *url.URL, other *url.URL) *url.URL { return withQuery(login, "state", "") },
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "state of another login",
+			callback:   func(login *url.URL, other *url.URL) *url.URL { return other },
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := proxytest.New(t, nil)
+
+			client := env.Client()
+			login := startLogin(t, env, client)
+			other := startLogin(t, env, env.Client())
+
+			if status := callback(t, client, tt.callback(login, other)); status != tt.wantStatus {
+				t.Fatalf("callback status = %d, want %d", status, tt.wantStatus)
+			}
+
+			// Only a successful callback starts a session
+			if status := apiStatus(t, env, client); (status == http.StatusOK) != (tt.wantStatus == http.StatusFound) {
+				t.Fatalf("API status = %d after callback status %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCallbackReplay(t *testing.T) {
+	env := proxytest.New(t, nil)
+
+	client := env.Client()
+	login := startLogin(t, env, client)
+
+	for i, want := range []int{http.StatusFound, http.StatusForbidden} {
+		if status := callback(t, client, login); status != want {
+			t.Fatalf("callback %d status = %d, want %d", i, status, want)
+		}
+	}
+}
+
+// withPKCE enables the PKCE code challenge of the OAuth2 login flow.
+func withPKCE(s *proxy.Server) error {
+	s.UsePKCE = true
+	return nil
+}
+
+func TestCallbackPKCE(t *testing.T) {
+	tests := []struct {
+		name string
+		// callback returns the callback URL the client requests, login is the client
+		// login callback URL, other is the callback URL of a login by another client.
+		callback   func(login *url.URL, other *url.URL) *url.URL
+		wantStatus int
+	}{
+		{
+			name:       "valid",
+			callback:   func(login *url.URL, other *url.URL) *url.URL { return login },
+			wantStatus: http.StatusFound,
+		},
+		{
+			name: "code of another login",
+			callback: func(login *url.URL, other *url.URL) *url.URL {
+				return withQuery(login, "code", other.Query().Get("code"))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := proxytest.New(t, nil, withPKCE)
+
+			client := env.Client()
+			login := startLogin(t, env, client)
+			other := startLogin(t, env, env.Client())
+
+			if login.Query().Get("code") == "" {
+				t.Fatalf("missing code in callback URL %s", login)
+			}
+			if status := callback(t, client, tt.callback(login, other)); status != tt.wantStatus {
+				t.Fatalf("callback status = %d, want %d", status, tt.wantStatus)
+			}
+			if status := apiStatus(t, env, client); (status == http.StatusOK) != (tt.wantStatus == http.StatusFound) {
+				t.Fatalf("API status = %d after callback status %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// withNonce enables the OpenID Connect nonce of the OAuth2 login flow.
+func withNonce(s *proxy.Server) error {
+	s.UseNonce = true
+	return nil
+}
+
+func TestCallbackNonce(t *testing.T) {
+	tests := []struct {
+		name string
+		// callback returns the callback URL the client requests, login is the client
+		// login callback URL, other is the callback URL of a login by another client.
+		callback   func(login *url.URL, other *url.URL) *url.URL
+		wantStatus int
+	}{
+		{
+			name:       "valid",
+			callback:   func(login *url.URL, other *url.URL) *url.URL { return login },
+			wantStatus: http.StatusFound,
+		},
+		{
+			name: "code of another login",
+			callback: func(login *url.URL, other *url.URL) *url.URL {
+				return withQuery(login, "code", other.Query().Get("code"))
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := proxytest.New(t, nil, withNonce)
+
+			client := env.Client()
+			login := startLogin(t, env, client)
+			other := startLogin(t, env, env.Client())
+
+			if status := callback(t, client, tt.callback(login, other)); status != tt.wantStatus {
+				t.Fatalf("callback status = %d, want %d", status, tt.wantStatus)
+			}
+			if status := apiStatus(t, env, client); (status == http.StatusOK) != (tt.wantStatus == http.StatusFound) {
+				t.Fatalf("API status = %d after callback status %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
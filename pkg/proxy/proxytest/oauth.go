@@ -0,0 +1,240 @@
+package proxytest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// ClientID is the OAuth2 client id accepted by the fake OAuth2 server.
+	ClientID = "proxytest"
+	// ClientSecret is the OAuth2 client secret accepted by the fake OAuth2 server,
+	// it is also the key of the HMAC signed id_tokens.
+	ClientSecret = "proxytest-secret"
+	// Subject is the sub claim of the tokens issued by the fake OAuth2 server.
+	Subject = "proxytest-user"
+
+	authorizePath = "/oauth/authorize"
+	tokenPath     = "/oauth/token"
+)
+
+// authRequest holds the authorization request parameters of an issued code.
+type authRequest struct {
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// OAuthServer is a fake OAuth2 / OpenID Connect server, issuing HMAC signed JWT
+// access tokens that a proxy Server using JWTKey accepts.
+type OAuthServer struct {
+	*httptest.Server
+
+	// JWTKey signs the access tokens.
+	JWTKey []byte
+	// TokenTTL is the access token lifetime.
+	TokenTTL time.Duration
+	// Claims are added to the access token claims, e.g. verbs and namespace.
+	Claims jwt.MapClaims
+
+	mu    sync.Mutex
+	codes map[string]authRequest
+}
+
+// NewOAuthServer starts a fake OAuth2 server issuing access tokens signed with jwtKey,
+// the tokens allow all verbs on all API groups unless Claims override them.
+func NewOAuthServer(jwtKey []byte) *OAuthServer {
+	o := &OAuthServer{
+		JWTKey:   jwtKey,
+		TokenTTL: time.Hour,
+		Claims: jwt.MapClaims{
+			"namespace": "*",
+			"verbs":     []interface{}{"get", "create", "update", "patch", "delete"},
+			"apiGroups": []interface{}{"*"},
+		},
+		codes: map[string]authRequest{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(authorizePath, o.authorize)
+	mux.HandleFunc(tokenPath, o.token)
+	o.Server = httptest.NewServer(mux)
+
+	return o
+}
+
+// Endpoint returns the OAuth2 endpoints of the server.
+func (o *OAuthServer) Endpoint() oauth2.Endpoint {
+	return oauth2.Endpoint{
+		AuthURL:  o.URL + authorizePath,
+		TokenURL: o.URL + tokenPath,
+	}
+}
+
+// OAuth2Config returns an OAuth2 config for the server client, a relative redirectURL is
+// resolved by the proxy Server against the request host.
+func (o *OAuthServer) OAuth2Config(redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     ClientID,
+		ClientSecret: ClientSecret,
+		Endpoint:     o.Endpoint(),
+		RedirectURL:  redirectURL,
+	}
+}
+
+// AccessToken returns a signed access token with the server claims and claims.
+func (o *OAuthServer) AccessToken(claims jwt.MapClaims) string {
+	c := jwt.MapClaims{
+		"iss": o.URL,
+		"sub": Subject,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(o.TokenTTL).Unix(),
+	}
+	for k, v := range o.Claims {
+		c[k] = v
+	}
+	for k, v := range claims {
+		c[k] = v
+	}
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString(o.JWTKey)
+	return token
+}
+
+// idToken returns an id_token for the client, signed with the client secret.
+func (o *OAuthServer) idToken(nonce string) string {
+	c := jwt.MapClaims{
+		"iss": o.URL,
+		"sub": Subject,
+		"aud": ClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(o.TokenTTL).Unix(),
+	}
+	if nonce != "" {
+		c["nonce"] = nonce
+	}
+
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(ClientSecret))
+	return token
+}
+
+// authorize approves every authorization request, and redirects back with a code.
+func (o *OAuthServer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != ClientID || q.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomCode()
+	o.mu.Lock()
+	o.codes[code] = authRequest{
+		redirectURI:   redirectURI.String(),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+	}
+	o.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+// token exchanges authorization codes and refresh tokens.
+func (o *OAuthServer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	// Authenticate the client, using basic auth or form parameters
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != ClientID || clientSecret != ClientSecret {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	nonce := ""
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		o.mu.Lock()
+		req, ok := o.codes[code]
+		delete(o.codes, code)
+		o.mu.Unlock()
+
+		if !ok || req.redirectURI != r.PostForm.Get("redirect_uri") {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		if req.codeChallenge != "" && pkceChallenge(r.PostForm.Get("code_verifier")) != req.codeChallenge {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+		nonce = req.nonce
+	case "refresh_token":
+		if r.PostForm.Get("refresh_token") == "" {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	default:
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  o.AccessToken(nil),
+		"token_type":    "Bearer",
+		"expires_in":    int(o.TokenTTL.Seconds()),
+		"refresh_token": randomCode(),
+		"id_token":      o.idToken(nonce),
+	})
+}
+
+// tokenError writes an OAuth2 error response (RFC 6749 5.2).
+func tokenError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// pkceChallenge returns the S256 code challenge of a code verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomCode() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,168 @@
+// Package proxytest runs a proxy Server against fake k8s API and OAuth2 servers,
+// started as httptest servers, to write integration tests of the proxy package.
+package proxytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/yaacov/kube-gateway/pkg/proxy"
+)
+
+const (
+	// APIPath is the gateway endpoint for API calls.
+	APIPath = "/k8s/"
+	// LoginEndpoint is the gateway OAuth2 login endpoint.
+	LoginEndpoint = "/auth/login"
+	// CallbackEndpoint is the gateway OAuth2 callback endpoint.
+	CallbackEndpoint = "/auth/callback"
+	// TokenEndpoint is the gateway endpoint setting a session token manually.
+	TokenEndpoint = "/auth/token"
+	// LogoutEndpoint is the gateway logout endpoint.
+	LogoutEndpoint = "/auth/logout"
+	// BearerToken is the operator token the fake k8s API server expects.
+	BearerToken = "proxytest-bearer-token"
+)
+
+// JWTKey is the key used to sign and verify the gateway JWT tokens.
+var JWTKey = []byte("proxytest-jwt-key")
+
+// Env holds a proxy Server wired to fake k8s API and OAuth2 servers.
+type Env struct {
+	// Server is the proxy Server under test.
+	Server *proxy.Server
+	// Gateway serves the proxy Server endpoints.
+	Gateway *httptest.Server
+	// API is the fake k8s API server.
+	API *httptest.Server
+	// OAuth is the fake OAuth2 server.
+	OAuth *OAuthServer
+}
+
+// New starts a fake k8s API server serving apiHandler, defaults to EchoHandler, a fake
+// OAuth2 server and a gateway serving a proxy Server configured to use them, opts are
+// applied after the default options. The servers are closed when the test ends.
+func New(tb testing.TB, apiHandler http.Handler, opts ...proxy.Option) *Env {
+	tb.Helper()
+
+	if apiHandler == nil {
+		apiHandler = EchoHandler()
+	}
+
+	env := &Env{
+		API:   httptest.NewServer(apiHandler),
+		OAuth: NewOAuthServer(JWTKey),
+	}
+	tb.Cleanup(env.Close)
+
+	defaults := []proxy.Option{
+		proxy.WithAPIServer(env.API.URL, nil),
+		proxy.WithAPIPath(APIPath),
+		proxy.WithOAuth(env.OAuth.OAuth2Config(CallbackEndpoint), env.OAuth.URL),
+		proxy.WithBearerToken(BearerToken),
+		proxy.WithJWTKey(JWTKey, nil),
+	}
+
+	s, err := proxy.NewServer(append(defaults, opts...)...)
+	if err != nil {
+		tb.Fatalf("fail to create proxy server: %v", err)
+	}
+	env.Server = s
+	env.Gateway = httptest.NewServer(s.ServeHandler(NewServeMux(s)))
+
+	return env
+}
+
+// NewServeMux registers the proxy Server endpoints, the same way kube-gateway does,
+// paths outside the API path are served by an authenticated "ok" handler.
+func NewServeMux(s *proxy.Server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(LoginEndpoint, s.Login)
+	mux.HandleFunc(CallbackEndpoint, s.Callback)
+	mux.HandleFunc(TokenEndpoint, s.Token)
+	mux.HandleFunc(LogoutEndpoint, s.Logout)
+
+	mux.Handle(s.APIPath, s.Handler())
+	mux.Handle("/", s.AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})))
+
+	return mux
+}
+
+// Close closes the gateway, k8s API and OAuth2 servers.
+func (e *Env) Close() {
+	for _, srv := range []*httptest.Server{e.Gateway, e.API, e.OAuth.Server} {
+		if srv != nil {
+			srv.Close()
+		}
+	}
+}
+
+// Token returns a gateway JWT token, signed with JWTKey, with the fake OAuth2 server
+// default claims and claims.
+func (e *Env) Token(claims jwt.MapClaims) string {
+	return e.OAuth.AccessToken(claims)
+}
+
+// Client returns an HTTP client with a cookie jar, that does not follow redirects.
+func (e *Env) Client() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Login runs the interactive OAuth2 login flow, and returns a client with the
+// gateway session cookie.
+func (e *Env) Login(tb testing.TB) *http.Client {
+	tb.Helper()
+
+	client := e.Client()
+	client.CheckRedirect = nil
+
+	resp, err := client.Get(e.Gateway.URL + LoginEndpoint)
+	if err != nil {
+		tb.Fatalf("fail to login: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		tb.Fatalf("fail to login: unexpected status %d", resp.StatusCode)
+	}
+
+	client.CheckRedirect = e.Client().CheckRedirect
+	return client
+}
+
+// Echo is the response of EchoHandler, describing the request the k8s API server got.
+type Echo struct {
+	Method        string      `json:"method"`
+	Path          string      `json:"path"`
+	RawQuery      string      `json:"rawQuery"`
+	Host          string      `json:"host"`
+	Authorization string      `json:"authorization"`
+	Header        http.Header `json:"header"`
+}
+
+// EchoHandler returns a fake k8s API handler, responding with the request it got as an Echo.
+func EchoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Echo{
+			Method:        r.Method,
+			Path:          r.URL.Path,
+			RawQuery:      r.URL.RawQuery,
+			Host:          r.Host,
+			Authorization: r.Header.Get("Authorization"),
+			Header:        r.Header,
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package proxytest_test
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/yaacov/kube-gateway/pkg/proxy/proxytest"
+)
+
+// slowStreamHandler returns a fake k8s API handler, writing a line and flushing it, then
+// waiting for next before writing the following line.
+func slowStreamHandler(lines int, next <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < lines; i++ {
+			fmt.Fprintf(w, "line %d\n", i)
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-next:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+}
+
+func TestStreaming(t *testing.T) {
+	const lines = 3
+
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "watch", path: "api/v1/namespaces/default/pods?watch=true"},
+		{name: "watch 1", path: "api/v1/namespaces/default/pods?watch=1"},
+		{name: "follow pod log", path: "api/v1/namespaces/default/pods/web/log?follow=true"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := make(chan struct{})
+			env := proxytest.New(t, slowStreamHandler(lines, next))
+
+			req, _ := http.NewRequest(http.MethodGet, env.Gateway.URL+proxytest.APIPath+tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+env.Token(nil))
+			resp, err := env.Client().Do(req)
+			if err != nil {
+				t.Fatalf("fail to call API: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+			}
+
+			// Each line must reach the client before the upstream writes the next one
+			br := bufio.NewReader(resp.Body)
+			for i := 0; i < lines; i++ {
+				read := make(chan string, 1)
+				go func() {
+					line, _ := br.ReadString('\n')
+					read <- line
+				}()
+
+				select {
+				case line := <-read:
+					if want := fmt.Sprintf("line %d\n", i); line != want {
+						t.Fatalf("line = %q, want %q", line, want)
+					}
+				case <-time.After(5 * time.Second):
+					t.Fatalf("line %d was not flushed to the client", i)
+				}
+				next <- struct{}{}
+			}
+		})
+	}
+}
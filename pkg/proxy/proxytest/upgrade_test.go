@@ -0,0 +1,217 @@
+package proxytest_test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yaacov/kube-gateway/pkg/proxy/proxytest"
+)
+
+// echoUpgradeHandler returns a fake k8s API handler, switching upgrade requests to the
+// requested protocol and echoing the raw bytes it receives after the upgrade.
+func echoUpgradeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Connection"), "upgrade") || r.Header.Get("Upgrade") == "" {
+			http.Error(w, "expected an upgrade request", http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: %s\r\nConnection: Upgrade\r\nX-Echo-Uri: %s\r\n\r\n",
+			r.Header.Get("Upgrade"), r.URL.RequestURI())
+		rw.Flush()
+
+		io.Copy(conn, rw)
+	})
+}
+
+// websocketFrame returns a masked client WebSocket frame (RFC 6455 5.2).
+func websocketFrame(opcode byte, payload []byte) []byte {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestUpgrade(t *testing.T) {
+	env := proxytest.New(t, echoUpgradeHandler())
+	gateway := httptest.NewTLSServer(env.Server.ServeHandler(proxytest.NewServeMux(env.Server)))
+	defer gateway.Close()
+
+	token := env.Token(nil)
+	requestURI := proxytest.APIPath + "api/v1/namespaces/default/pods/web/exec?command=sh&stdin=true&tty=true"
+
+	tests := []struct {
+		name    string
+		upgrade string
+		frames  [][]byte
+	}{
+		{
+			name:    "websocket binary frame",
+			upgrade: "websocket",
+			frames:  [][]byte{websocketFrame(0x2, []byte{0x00, 0x01, 0xff, 0xfe, '\r', '\n'})},
+		},
+		{
+			name:    "websocket ping and pong",
+			upgrade: "websocket",
+			frames:  [][]byte{websocketFrame(0x9, []byte("ping")), websocketFrame(0xa, []byte("pong"))},
+		},
+		{
+			name:    "websocket text and close",
+			upgrade: "websocket",
+			frames:  [][]byte{websocketFrame(0x1, []byte("ls -l\n")), websocketFrame(0x8, []byte{0x03, 0xe8})},
+		},
+		{
+			name:    "SPDY raw bytes",
+			upgrade: "SPDY/3.1",
+			frames:  [][]byte{{0x80, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", gateway.Listener.Addr().String(), gateway.Client().Transport.(*http.Transport).TLSClientConfig)
+			if err != nil {
+				t.Fatalf("fail to dial gateway: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: %s\r\n"+
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nAuthorization: Bearer %s\r\n\r\n",
+				requestURI, gateway.Listener.Addr(), tt.upgrade, token)
+
+			br := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("fail to read upgrade response: %v", err)
+			}
+			if resp.StatusCode != http.StatusSwitchingProtocols {
+				t.Fatalf("upgrade status = %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+			}
+			if got, want := resp.Header.Get("X-Echo-Uri"), strings.TrimPrefix(requestURI, strings.TrimSuffix(proxytest.APIPath, "/")); got != want {
+				t.Fatalf("upstream request URI = %s, want %s", got, want)
+			}
+
+			for _, frame := range tt.frames {
+				if _, err := conn.Write(frame); err != nil {
+					t.Fatalf("fail to write frame: %v", err)
+				}
+
+				echo := make([]byte, len(frame))
+				if _, err := io.ReadFull(br, echo); err != nil {
+					t.Fatalf("fail to read frame: %v", err)
+				}
+				if !bytes.Equal(echo, frame) {
+					t.Fatalf("echoed frame = %x, want %x", echo, frame)
+				}
+			}
+		})
+	}
+}
+
+// execProtocol is the only WebSocket subprotocol accepted by subprotocolUpgradeHandler.
+const execProtocol = "v4.channel.k8s.io"
+
+// subprotocolUpgradeHandler returns a fake k8s API handler, switching WebSocket requests
+// offering execProtocol and echoing it as the negotiated subprotocol. Requests offering
+// other subprotocols only, or a bearer token subprotocol, are rejected.
+func subprotocolUpgradeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted := false
+		for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+			for _, p := range strings.Split(value, ",") {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "base64url.bearer.authorization.k8s.io.") {
+					http.Error(w, "bearer token subprotocol forwarded", http.StatusBadRequest)
+					return
+				}
+				accepted = accepted || p == execProtocol
+			}
+		}
+		if !accepted {
+			http.Error(w, "unsupported subprotocol", http.StatusBadRequest)
+			return
+		}
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Protocol: %s\r\n\r\n", execProtocol)
+		rw.Flush()
+	})
+}
+
+func TestUpgradeSubprotocol(t *testing.T) {
+	env := proxytest.New(t, subprotocolUpgradeHandler())
+
+	token := env.Token(nil)
+	bearerProtocol := "base64url.bearer.authorization.k8s.io." + base64.RawURLEncoding.EncodeToString([]byte(token))
+	requestURI := proxytest.APIPath + "api/v1/namespaces/default/pods/web/exec?command=sh&stdin=true"
+
+	tests := []struct {
+		name          string
+		protocols     string
+		authorization bool
+		wantStatus    int
+		wantProtocol  string
+	}{
+		{name: "exec subprotocol", protocols: execProtocol, authorization: true, wantStatus: http.StatusSwitchingProtocols, wantProtocol: execProtocol},
+		{name: "several subprotocols", protocols: "v5.channel.k8s.io, " + execProtocol, authorization: true, wantStatus: http.StatusSwitchingProtocols, wantProtocol: execProtocol},
+		{name: "bearer token subprotocol", protocols: bearerProtocol + ", " + execProtocol, wantStatus: http.StatusSwitchingProtocols, wantProtocol: execProtocol},
+		{name: "unsupported subprotocol", protocols: "v3.channel.k8s.io", authorization: true, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", env.Gateway.Listener.Addr().String())
+			if err != nil {
+				t.Fatalf("fail to dial gateway: %v", err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+			authorization := ""
+			if tt.authorization {
+				authorization = "Authorization: Bearer " + token + "\r\n"
+			}
+			fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+				"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Protocol: %s\r\n%s\r\n",
+				requestURI, env.Gateway.Listener.Addr(), tt.protocols, authorization)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				t.Fatalf("fail to read upgrade response: %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("upgrade status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != tt.wantProtocol {
+				t.Fatalf("negotiated subprotocol = %q, want %q", got, tt.wantProtocol)
+			}
+		})
+	}
+}
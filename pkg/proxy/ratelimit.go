@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRateLimitMaxClients is the number of clients tracked by the rate limiter.
+const defaultRateLimitMaxClients = 10000
+
+// RateLimitConfig holds the token bucket rate limit applied to each client.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the rate at which each client bucket is refilled.
+	RequestsPerSecond float64
+	// Burst is the bucket size, defaults to RequestsPerSecond rounded up.
+	Burst int
+	// MaxClients is the number of tracked clients, the least recently seen client
+	// is evicted when full, defaults to 10000.
+	MaxClients int
+}
+
+// rateLimiter keeps a token bucket per client key, with LRU eviction.
+type rateLimiter struct {
+	rate       float64
+	burst      float64
+	maxClients int
+
+	mu      sync.Mutex
+	clients map[string]*list.Element
+	lru     *list.List
+}
+
+// bucket is a client token bucket.
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(c *RateLimitConfig) *rateLimiter {
+	burst := float64(c.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(c.RequestsPerSecond))
+	}
+	maxClients := c.MaxClients
+	if maxClients <= 0 {
+		maxClients = defaultRateLimitMaxClients
+	}
+
+	return &rateLimiter{
+		rate:       c.RequestsPerSecond,
+		burst:      burst,
+		maxClients: maxClients,
+		clients:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+}
+
+// allow takes a token from the client bucket, if the bucket is empty it returns
+// false and the time until a token is available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	var b *bucket
+	if e, ok := l.clients[key]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	} else {
+		// Evict the least recently seen client
+		if l.lru.Len() >= l.maxClients {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.clients, oldest.Value.(*bucket).key)
+		}
+
+		b = &bucket{key: key, tokens: l.burst, last: now}
+		l.clients[key] = l.lru.PushFront(b)
+	}
+
+	if b.tokens < 1 {
+		if l.rate <= 0 {
+			return false, time.Second
+		}
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// RateLimitMiddleware limits the request rate of each client, clients are identified
+// by the sub claim of a valid token, or by their IP address. The token is verified using
+// verifyToken, and the result is reused by AuthMiddleware.
+// When RateLimit is not configured, requests are passed to next unchanged.
+func (s *Server) RateLimitMiddleware(next http.Handler) http.Handler {
+	if s.RateLimit == nil || s.RateLimit.RequestsPerSecond <= 0 {
+		return next
+	}
+	limiter := newRateLimiter(s.RateLimit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = withRequestInfo(r)
+		key := s.rateLimitKey(r)
+
+		if ok, retryAfter := limiter.allow(key); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			s.writeError(w, r, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded, retry after %ds", seconds))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitKey returns the key identifying a client for rate limiting. Claims validated by
+// AuthMiddleware are used when the middleware runs after it, otherwise the request token is
+// verified, using the validation cache and TokenReview when configured. Tokens are not
+// verified in passthrough mode, and clients are identified by their IP address.
+func (s *Server) rateLimitKey(r *http.Request) string {
+	claims := ClaimsFromContext(r.Context())
+	if claims == nil && !s.BearerTokenPassthrough {
+		if token, _ := s.GetRequestToken(r); token != "" {
+			claims, _ = s.verifyRequestToken(r.Context(), r, token)
+		}
+	}
+
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		return "sub:" + sub
+	}
+
+	return "ip:" + s.clientIP(r)
+}
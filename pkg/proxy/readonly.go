@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// readOnlyAllowHeader lists the methods allowed in ReadOnly mode.
+const readOnlyAllowHeader = "GET, HEAD, OPTIONS"
+
+// isMutatingMethod checks if a request method may change k8s resources.
+func isMutatingMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// checkReadOnly returns an error for mutating requests in ReadOnly mode, unless the
+// request token explicitly permits the method using the methods claim.
+func (s *Server) checkReadOnly(r *http.Request) error {
+	if !s.ReadOnly || !isMutatingMethod(r.Method) {
+		return nil
+	}
+
+	// Only an explicit method is allowed, a "*" in the methods claim does not override ReadOnly.
+	for _, m := range claimStrings(ClaimsFromContext(r.Context()), "methods") {
+		if strings.EqualFold(m, r.Method) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("method %s is not allowed, the gateway is read only", r.Method)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"net/url"
+	"strings"
+)
+
+// isLocalRedirect checks that a redirect target is a local path, e.g. "/ui/pods?ns=a",
+// absolute URLs, protocol relative URLs ("//host") and backslash tricks are rejected.
+func isLocalRedirect(target string) bool {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") {
+		return false
+	}
+
+	// Browsers ignore tabs and new lines in URLs, e.g. "/\t/host"
+	if strings.ContainsAny(target, "\t\r\n\\") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	return u.Scheme == "" && u.Host == "" && u.User == nil
+}
+
+// localRedirect returns the redirect target if it is a local path, otherwise "/".
+func localRedirect(target string) string {
+	if isLocalRedirect(target) {
+		return target
+	}
+	return "/"
+}
+
+// safeRedirect returns the redirect target if it is a local path, or an http(s) URL of
+// one of the AllowedRedirectHosts, otherwise "/".
+func (s *Server) safeRedirect(target string) string {
+	if isLocalRedirect(target) {
+		return target
+	}
+
+	if len(s.AllowedRedirectHosts) == 0 || strings.ContainsAny(target, "\t\r\n\\") {
+		return "/"
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return "/"
+	}
+
+	for _, host := range s.AllowedRedirectHosts {
+		if strings.EqualFold(u.Host, host) {
+			return target
+		}
+	}
+
+	return "/"
+}
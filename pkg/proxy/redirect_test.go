@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		allowedHosts []string
+		target       string
+		want         string
+	}{
+		{name: "empty", target: "", want: "/"},
+		{name: "relative path", target: "/ui/pods?ns=a", want: "/ui/pods?ns=a"},
+		{name: "root", target: "/", want: "/"},
+		{name: "path without leading slash", target: "ui/pods", want: "/"},
+		{name: "absolute URL", target: "https://evil.com", want: "/"},
+		{name: "absolute URL path", target: "https://evil.com/ui", want: "/"},
+		{name: "protocol relative URL", target: "//evil.com", want: "/"},
+		{name: "backslash protocol relative URL", target: "/\\evil.com", want: "/"},
+		{name: "tab protocol relative URL", target: "/\t/evil.com", want: "/"},
+		{name: "javascript URL", target: "javascript:alert(1)", want: "/"},
+		{name: "allowed host", allowedHosts: []string{"console.example.com"}, target: "https://console.example.com/ui", want: "https://console.example.com/ui"},
+		{name: "allowed host case", allowedHosts: []string{"console.example.com"}, target: "https://Console.Example.com/ui", want: "https://Console.Example.com/ui"},
+		{name: "not allowed host", allowedHosts: []string{"console.example.com"}, target: "https://evil.com", want: "/"},
+		{name: "allowed host with user info", allowedHosts: []string{"console.example.com"}, target: "https://user@console.example.com/ui", want: "/"},
+		{name: "allowed host other scheme", allowedHosts: []string{"console.example.com"}, target: "ftp://console.example.com", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{AllowedRedirectHosts: tt.allowedHosts}
+
+			if got := s.safeRedirect(tt.target); got != tt.want {
+				t.Fatalf("safeRedirect(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenRedirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		then         string
+		wantLocation string
+	}{
+		{name: "relative path", method: http.MethodGet, then: "/ui/pods", wantLocation: "/ui/pods"},
+		{name: "absolute URL", method: http.MethodGet, then: "https://evil.com", wantLocation: "/"},
+		{name: "protocol relative URL", method: http.MethodGet, then: "//evil.com", wantLocation: "/"},
+		{name: "post relative path", method: http.MethodPost, then: "/ui/pods", wantLocation: "/ui/pods"},
+		{name: "post absolute URL", method: http.MethodPost, then: "https://evil.com", wantLocation: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+
+			params := url.Values{"token": {"token"}, "then": {tt.then}}
+			r := httptest.NewRequest(http.MethodGet, "/auth/token?"+params.Encode(), nil)
+			if tt.method == http.MethodPost {
+				r = httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(params.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			w := httptest.NewRecorder()
+			s.Token(w, r)
+
+			if w.Code != http.StatusFound {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusFound)
+			}
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Fatalf("Location = %q, want %q", got, tt.wantLocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	ocgateOAuthTokenCookieName = "ocgate-oauth-token"
+
+	// defaultRefreshThreshold is the remaining token lifetime that triggers a token refresh.
+	defaultRefreshThreshold = 60 * time.Second
+
+	// refreshResultTTL is the time a refreshed token is reused by requests that still
+	// carry the old refresh token, e.g. requests sent before the new cookies were set.
+	refreshResultTTL = 10 * time.Second
+)
+
+// setOAuthTokenCookie stores the encrypted OAuth2 token, including the refresh token,
+// the cookie outlives the access token and expires after SessionMaxAge.
+func (s *Server) setOAuthTokenCookie(w http.ResponseWriter, r *http.Request, tok *oauth2.Token) error {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+
+	value, err := encryptCookieValue(s.encryptionKey(), string(b))
+	if err != nil {
+		return err
+	}
+
+	cookie := s.newCookie(r, s.oauthTokenCookieName(), value)
+	s.setCookieExpiry(cookie, time.Time{})
+
+	http.SetCookie(w, cookie)
+
+	return nil
+}
+
+// getOAuthTokenCookie reads the encrypted OAuth2 token set by setOAuthTokenCookie.
+func (s *Server) getOAuthTokenCookie(r *http.Request) (*oauth2.Token, error) {
+	cookie, err := r.Cookie(s.oauthTokenCookieName())
+	if err != nil || cookie.Value == "" {
+		return nil, fmt.Errorf("missing oauth token cookie")
+	}
+
+	value, err := decryptCookieValue(s.encryptionKey(), cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var tok oauth2.Token
+	if err := json.Unmarshal([]byte(value), &tok); err != nil {
+		return nil, err
+	}
+
+	return &tok, nil
+}
+
+// refreshToken refreshes the OAuth2 token stored in the session when it is about to expire,
+// it returns the new access token, or an empty string if no refresh was needed.
+func (s *Server) refreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request) (string, error) {
+	tok, err := s.sessionOAuthToken(r)
+	if err != nil || tok.RefreshToken == "" || tok.Expiry.IsZero() {
+		return "", nil
+	}
+
+	threshold := s.RefreshThreshold
+	if threshold <= 0 {
+		threshold = defaultRefreshThreshold
+	}
+	if time.Until(tok.Expiry) > threshold {
+		return "", nil
+	}
+
+	// Refresh using the identity provider that issued the access token
+	conf := s.Auth2Config
+	if provider := s.tokenProvider(tok.AccessToken); provider != nil {
+		conf = provider.Auth2Config
+	}
+	if conf == nil {
+		return "", nil
+	}
+
+	// Concurrent requests of a session share a single refresh, keyed by the refresh token.
+	newTok, err := s.refreshes().do(ctx, TokenHash(tok.RefreshToken), func() (*oauth2.Token, error) {
+		// The refresh is not cancelled when the first request is, other requests wait for it.
+		ctx, cancel := context.WithTimeout(context.Background(), s.oauthExchangeTimeout())
+		defer cancel()
+
+		// Use the custom HTTP client when requesting a token.
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, s.oauthHTTPClient())
+
+		// A token without an access token is always refreshed by the token source.
+		newTok, err := conf.TokenSource(ctx, &oauth2.Token{RefreshToken: tok.RefreshToken}).Token()
+		if err != nil {
+			return nil, err
+		}
+
+		s.Metrics.tokenRefresh()
+		s.logger().Info("refreshed oauth token", s.requestAttrs(r)...)
+		return newTok, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("fail to refresh token: %+v", err)
+	}
+
+	if err := s.updateSession(w, r, newTok); err != nil {
+		return "", err
+	}
+
+	return newTok.AccessToken, nil
+}
+
+// refreshCall is an in-flight or recently completed token refresh.
+type refreshCall struct {
+	done     chan struct{}
+	tok      *oauth2.Token
+	err      error
+	finished time.Time
+}
+
+// refreshGroup deduplicates concurrent token refreshes, so a session refreshes its token
+// once, and the other requests wait for the result. Successful results are kept for
+// refreshResultTTL, for requests sent with the old refresh token before the session was updated.
+type refreshGroup struct {
+	mu    sync.Mutex
+	calls map[string]*refreshCall
+}
+
+// refreshes returns the server refresh group.
+func (s *Server) refreshes() *refreshGroup {
+	s.refreshOnce.Do(func() {
+		s.refreshGroup = &refreshGroup{calls: map[string]*refreshCall{}}
+	})
+
+	return s.refreshGroup
+}
+
+// do calls fn once for concurrent calls with the same key, and returns its result.
+func (g *refreshGroup) do(ctx context.Context, key string, fn func() (*oauth2.Token, error)) (*oauth2.Token, error) {
+	g.mu.Lock()
+	now := time.Now()
+	for k, c := range g.calls {
+		if !c.finished.IsZero() && now.Sub(c.finished) > refreshResultTTL {
+			delete(g.calls, k)
+		}
+	}
+
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+
+		select {
+		case <-c.done:
+			return c.tok, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	c := &refreshCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.tok, c.err = fn()
+
+	// Failed refreshes are not kept, the next request tries again
+	g.mu.Lock()
+	c.finished = time.Now()
+	if c.err != nil {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+	close(c.done)
+
+	return c.tok, c.err
+}
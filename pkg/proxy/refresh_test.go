@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshGroup(t *testing.T) {
+	const requests = 20
+
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int32
+	}{
+		{name: "successful refresh", wantCalls: 1},
+		{name: "failed refresh", err: errors.New("refresh failed"), wantCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &refreshGroup{calls: map[string]*refreshCall{}}
+			release := make(chan struct{})
+			var calls int32
+
+			fn := func() (*oauth2.Token, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return &oauth2.Token{AccessToken: "new-token"}, nil
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, requests)
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					tok, err := g.do(context.Background(), "key", fn)
+					if err == nil && tok.AccessToken != "new-token" {
+						err = errors.New("unexpected access token " + tok.AccessToken)
+					}
+					if err != nil && err != tt.err {
+						errs <- err
+					}
+				}()
+			}
+
+			// Let all requests join the in-flight refresh
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Fatalf("do() error = %v", err)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Fatalf("refresh calls = %d, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenConcurrent(t *testing.T) {
+	const requests = 20
+
+	var exchanges int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" {
+			http.Error(w, "invalid_request", http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(&exchanges, 1)
+
+		// Keep the refresh in-flight while the other requests arrive
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "new-token",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"refresh_token": "new-refresh-token",
+		})
+	}))
+	defer tokenServer.Close()
+
+	s := &Server{Auth2Config: &oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{TokenURL: tokenServer.URL}}}
+
+	// A session with a token about to expire
+	session := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+	if err := s.setOAuthTokenCookie(session, r, &oauth2.Token{AccessToken: "old-token", RefreshToken: "refresh-token", Expiry: time.Now().Add(time.Second)}); err != nil {
+		t.Fatalf("setOAuthTokenCookie() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	tokens := make(chan string, requests)
+	for i := 0; i < requests; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil)
+		for _, c := range session.Result().Cookies() {
+			r.AddCookie(c)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := s.refreshToken(r.Context(), httptest.NewRecorder(), r)
+			if err != nil {
+				t.Errorf("refreshToken() error = %v", err)
+			}
+			tokens <- token
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	for token := range tokens {
+		if token != "new-token" {
+			t.Fatalf("refreshToken() = %q, want %q", token, "new-token")
+		}
+	}
+	if got := atomic.LoadInt32(&exchanges); got != 1 {
+		t.Fatalf("token exchanges = %d, want 1", got)
+	}
+}
@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Reloadable is the Server keys and policy configuration that can be replaced using
+// Reload while serving, the listener, routes and OAuth2 configuration are fixed.
+type Reloadable struct {
+	// BearerToken is the operator token used for k8s API calls, when BearerTokenFile
+	// is set the file is read again instead.
+	BearerToken string
+	// JWTTokenKey is the key of HMAC signed tokens.
+	JWTTokenKey []byte
+	// JWTTokenRSAKey is the public key of RSA signed tokens.
+	JWTTokenRSAKey *rsa.PublicKey
+	// JWKSURL is the JSON Web Key Set endpoint, the keys are fetched again after a reload.
+	JWKSURL string
+
+	// PathRules allow or deny requests by method and API path regular expression.
+	PathRules []PathRule
+	// PathRulesDefaultDeny denies requests not matching any of the PathRules.
+	PathRulesDefaultDeny bool
+	// AllowedGroups if set, tokens must have one of the groups in their groups claim.
+	AllowedGroups []string
+
+	jwks        *jwksCache
+	validations *validationCache
+}
+
+// Reload validates and replaces the keys and policy configuration. The new configuration
+// is swapped atomically, requests in flight keep using the configuration they started
+// with, and connections are not dropped. The bearer token file is read again, and the
+// new configuration starts with an empty validation cache so tokens are validated using
+// the new keys, validations of requests in flight are added to the replaced cache.
+func (s *Server) Reload(config Reloadable) error {
+	config.PathRules = append([]PathRule(nil), config.PathRules...)
+	if err := compilePathRules(config.PathRules); err != nil {
+		return err
+	}
+
+	if s.BearerTokenPassthrough && config.BearerToken != "" {
+		return fmt.Errorf("bearer token and bearer token passthrough are mutually exclusive")
+	}
+	if !s.BearerTokenPassthrough && config.BearerToken == "" && s.BearerTokenFile == "" && s.ClientCert == nil {
+		return fmt.Errorf("missing bearer token, set a bearer token, a client certificate or bearer token passthrough")
+	}
+	if err := validateTokenReview(s.TokenReviewValidation, s.ImpersonateUsers); err != nil {
+		return err
+	}
+	if !s.BearerTokenPassthrough && !s.TokenReviewValidation && len(config.JWTTokenKey) == 0 && config.JWTTokenRSAKey == nil && config.JWKSURL == "" {
+		return fmt.Errorf("validating JWT tokens requires a JWT key")
+	}
+	if err := validateJWTKey(config.JWTTokenKey); err != nil {
+		return err
+	}
+
+	if s.BearerTokenFile != "" {
+		f := s.bearerTokenFile()
+		f.expire()
+		if _, err := f.get(); err != nil {
+			return err
+		}
+	}
+
+	if config.JWKSURL != "" {
+		config.jwks = s.newJWKSCache(config.JWKSURL)
+	}
+
+	config.validations = s.newValidationCache()
+
+	s.reloaded.Store(&config)
+
+	s.logger().Info("configuration reloaded", "pathRules", len(config.PathRules), "allowedGroups", len(config.AllowedGroups))
+	return nil
+}
+
+// ReloadConfig reads the keys and policy configuration from a LoadConfig file, and
+// replaces the current configuration using Reload. When the file sets no JWT key or JWKS
+// URL the current keys are kept, other config fields are ignored. A file enabling token
+// review without user impersonation is rejected, as LoadConfig does.
+func (s *Server) ReloadConfig(path string) error {
+	config, err := s.readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	return s.Reload(*config)
+}
+
+// ApplyConfig reads the keys and policy configuration from a LoadConfig file into the
+// Server fields, as ReloadConfig does, without validating them. It is used on startup
+// before Validate, so the configuration is validated once with the file applied, use
+// ReloadConfig while serving.
+func (s *Server) ApplyConfig(path string) error {
+	config, err := s.readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	s.JWTTokenKey = config.JWTTokenKey
+	s.JWTTokenRSAKey = config.JWTTokenRSAKey
+	s.JWKSURL = config.JWKSURL
+	s.PathRules = config.PathRules
+	s.PathRulesDefaultDeny = config.PathRulesDefaultDeny
+	s.AllowedGroups = config.AllowedGroups
+	return nil
+}
+
+// readConfig reads the keys and policy configuration from a LoadConfig file, keeping the
+// current keys when the file sets none.
+func (s *Server) readConfig(path string) (*Reloadable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read config file: %+v", err)
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("fail to parse config file %s: %+v", path, err)
+	}
+
+	if err := validateTokenReview(config.TokenReview, config.ImpersonateUsers); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %+v", path, err)
+	}
+
+	key, rsaKey, err := config.JWT.keys()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %+v", path, err)
+	}
+
+	current := s.current()
+	jwksURL := config.JWT.JWKSURL
+	if len(key) == 0 && rsaKey == nil && jwksURL == "" {
+		key, rsaKey, jwksURL = current.JWTTokenKey, current.JWTTokenRSAKey, current.JWKSURL
+	}
+
+	return &Reloadable{
+		BearerToken:          current.BearerToken,
+		JWTTokenKey:          key,
+		JWTTokenRSAKey:       rsaKey,
+		JWKSURL:              jwksURL,
+		PathRules:            config.PathRules,
+		PathRulesDefaultDeny: config.PathRulesDefaultDeny,
+		AllowedGroups:        config.AllowedGroups,
+	}, nil
+}
+
+// current returns the keys and policy configuration in use, set by the last Reload,
+// or the Server fields.
+func (s *Server) current() *Reloadable {
+	if config := s.reloaded.Load(); config != nil {
+		return config
+	}
+
+	config := &Reloadable{
+		BearerToken:          s.BearerToken,
+		JWTTokenKey:          s.JWTTokenKey,
+		JWTTokenRSAKey:       s.JWTTokenRSAKey,
+		JWKSURL:              s.JWKSURL,
+		PathRules:            s.PathRules,
+		PathRulesDefaultDeny: s.PathRulesDefaultDeny,
+		AllowedGroups:        s.AllowedGroups,
+	}
+	if config.JWKSURL != "" {
+		config.jwks = s.jwksKeys()
+	}
+	config.validations = s.validations()
+
+	return config
+}
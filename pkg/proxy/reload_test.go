@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestReloadTokenReview(t *testing.T) {
+	tests := []struct {
+		name             string
+		tokenReview      bool
+		impersonateUsers bool
+		wantErr          bool
+	}{
+		{name: "JWT validation"},
+		{name: "token review with user impersonation", tokenReview: true, impersonateUsers: true},
+		{name: "token review without user impersonation", tokenReview: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{TokenReviewValidation: tt.tokenReview, ImpersonateUsers: tt.impersonateUsers}
+
+			if err := s.Reload(Reloadable{BearerToken: "token", JWTTokenKey: testJWTKey}); (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadConfigTokenReview(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "no token review", config: "apiServerURL: https://kubernetes.default.svc\n"},
+		{name: "token review with user impersonation", config: "apiServerURL: https://kubernetes.default.svc\ntokenReview: true\nimpersonateUsers: true\n"},
+		{name: "token review without user impersonation", config: "apiServerURL: https://kubernetes.default.svc\ntokenReview: true\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			s := &Server{BearerToken: "token", JWTTokenKey: testJWTKey}
+			if err := s.ReloadConfig(path); (err != nil) != tt.wantErr {
+				t.Fatalf("ReloadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  *Server
+		config  string
+		wantErr bool
+	}{
+		{
+			name:   "JWT key from config file",
+			server: &Server{APIServerURL: "https://kubernetes.default.svc", APIPath: "/k8s/", BearerToken: "token"},
+			config: "apiServerURL: https://kubernetes.default.svc\njwt:\n  hmacKey: dGVzdC1qd3Qta2V5\n",
+		},
+		{
+			name:   "JWT key from server",
+			server: &Server{APIServerURL: "https://kubernetes.default.svc", APIPath: "/k8s/", BearerToken: "token", JWTTokenKey: testJWTKey},
+			config: "apiServerURL: https://kubernetes.default.svc\nallowedGroups: [admins]\n",
+		},
+		{
+			name:    "no JWT key",
+			server:  &Server{APIServerURL: "https://kubernetes.default.svc", APIPath: "/k8s/", BearerToken: "token"},
+			config:  "apiServerURL: https://kubernetes.default.svc\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid path rule",
+			server:  &Server{APIServerURL: "https://kubernetes.default.svc", APIPath: "/k8s/", BearerToken: "token", JWTTokenKey: testJWTKey},
+			config:  "apiServerURL: https://kubernetes.default.svc\npathRules:\n- pattern: \"^api/v1/(pods\"\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.server.ApplyConfig(path); err != nil {
+				t.Fatalf("ApplyConfig() error = %v", err)
+			}
+			if err := tt.server.Validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadValidationCache(t *testing.T) {
+	token := signHS256(t, testJWTKey, jwt.MapClaims{"sub": "user"})
+
+	s := &Server{BearerToken: "token", JWTTokenKey: testJWTKey, ValidationCacheTTL: time.Minute}
+	if _, err := s.verifyToken(context.Background(), token); err != nil {
+		t.Fatalf("verifyToken() error = %v", err)
+	}
+
+	// A request validating the token with the old keys, finishing after the reload
+	old := s.current()
+	if err := s.Reload(Reloadable{BearerToken: "token", JWTTokenKey: []byte("other-key")}); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	old.validations.add(TokenHash(token), jwt.MapClaims{"sub": "user"}, time.Now().Add(time.Minute))
+
+	if _, err := s.verifyToken(context.Background(), token); err == nil {
+		t.Fatalf("verifyToken() error = nil, want an error for a token validated with the old keys")
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+const (
+	// requestIDHeader is the header holding the request ID, it is passed to the k8s API server
+	// and returned to the client.
+	requestIDHeader = "X-Request-Id"
+
+	// maxRequestIDLength is the max length of a request ID received from a client.
+	maxRequestIDLength = 128
+
+	// requestIDLength is the number of random bytes in a generated request ID.
+	requestIDLength = 16
+)
+
+// requestIDKey is the context key of the request ID.
+type requestIDKey struct{}
+
+// RequestIDMiddleware sets a request ID on each request, an incoming X-Request-Id header
+// is respected when valid. The ID is set on the proxied request and response headers, and is
+// available to downstream handlers using RequestIDFromContext.
+func (s *Server) RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			if id, err = randomString(requestIDLength); err != nil {
+				s.writeError(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		r.Header.Set(requestIDHeader, id)
+		w.Header().Set(requestIDHeader, id)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequestIDFromContext returns the request ID set by RequestIDMiddleware,
+// or an empty string if none is set.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID checks that a request ID is short and only uses printable ASCII characters.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
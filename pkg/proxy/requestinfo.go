@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// requestInfoContextKey is the request context key of the requestInfo.
+var requestInfoContextKey = &contextKey{"request-info"}
+
+// requestInfo collects values set by inner handlers, e.g. the validated claims and the
+// k8s API server response status, for the outer middlewares that log the request.
+type requestInfo struct {
+	claims         jwt.MapClaims
+	upstreamStatus int
+
+	// verification is the result of verifying the request token, shared by the rate
+	// limit and authentication middlewares so a token is verified once per request.
+	verification *tokenVerification
+}
+
+// tokenVerification is the result of verifying a token.
+type tokenVerification struct {
+	token  string
+	claims jwt.MapClaims
+	err    error
+}
+
+// withRequestInfo returns the request with a requestInfo in its context, and the info,
+// an existing requestInfo is kept so all the middlewares share it.
+func withRequestInfo(r *http.Request) (*http.Request, *requestInfo) {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		return r, info
+	}
+
+	info := &requestInfo{}
+	return r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info)), info
+}
+
+// requestInfoFromContext returns the request requestInfo, or nil if not set.
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
+
+// recordUpstreamStatus keeps the k8s API server response status in the request info,
+// before the response is modified, e.g. 401 responses replaced by a login redirect.
+func recordUpstreamStatus(resp *http.Response) {
+	if resp.Request == nil {
+		return
+	}
+	if info := requestInfoFromContext(resp.Request.Context()); info != nil {
+		info.upstreamStatus = resp.StatusCode
+	}
+}
+
+// verifyRequestToken verifies the request token using verifyToken, the result is kept in
+// the request info, and reused when the same token is verified again during the request.
+func (s *Server) verifyRequestToken(ctx context.Context, r *http.Request, token string) (jwt.MapClaims, error) {
+	info := requestInfoFromContext(r.Context())
+	if info != nil && info.verification != nil && info.verification.token == token {
+		return info.verification.claims, info.verification.err
+	}
+
+	claims, err := s.verifyToken(ctx, token)
+	if info != nil {
+		info.verification = &tokenVerification{token: token, claims: claims, err: err}
+	}
+	return claims, err
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultRetryBackoff is the delay before the first retry, the delay doubles on each retry.
+	defaultRetryBackoff = 100 * time.Millisecond
+
+	// maxRetryAfter is the longest upstream Retry-After delay the retry transport waits,
+	// responses asking for a longer delay are returned to the client.
+	maxRetryAfter = 10 * time.Second
+)
+
+// retryTransport is a RoundTripper that retries idempotent requests on connection errors
+// and 503 responses, using exponential backoff, or the upstream Retry-After delay. 429
+// responses are retried only when they have a Retry-After header.
+type retryTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+}
+
+// newRetryTransport wraps a RoundTripper with a retry policy, a backoff of zero means
+// the default of 100ms.
+func newRetryTransport(next http.RoundTripper, maxRetries int, backoff time.Duration) *retryTransport {
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	return &retryTransport{next: next, maxRetries: maxRetries, backoff: backoff}
+}
+
+// RoundTrip sends the request, retrying idempotent requests up to maxRetries times.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if !isRetryableRequest(req) {
+		return resp, err
+	}
+
+	delay := t.backoff
+	for retry := 0; retry < t.maxRetries && shouldRetry(req.Context(), resp, err); retry++ {
+		// Prefer the upstream Retry-After delay over the backoff
+		wait := delay
+		if resp != nil {
+			if after, ok := retryAfter(resp, time.Now()); ok {
+				if after > maxRetryAfter {
+					break
+				}
+				wait = after
+			}
+		}
+		delay *= 2
+
+		// Release the failed response before retrying
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+
+		if req, err = rewindRequest(req); err != nil {
+			return nil, err
+		}
+		resp, err = t.next.RoundTrip(req)
+	}
+
+	return resp, err
+}
+
+// isRetryableRequest checks that a request is idempotent and can be sent again,
+// non-idempotent methods and upgrade requests are never retried.
+func isRetryableRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		return false
+	}
+
+	if isUpgradeRequest(req) {
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// shouldRetry checks if a round trip failed with a connection error, a 503 response,
+// or a 429 response with a Retry-After header.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusServiceUnavailable:
+		return true
+	case http.StatusTooManyRequests:
+		return resp.Header.Get("Retry-After") != ""
+	default:
+		return false
+	}
+}
+
+// retryAfter returns the delay asked by the Retry-After header of a 429 or 503 response,
+// in delay seconds or HTTP-date form (RFC 7231 7.1.3), a date in the past means no delay.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		// Avoid overflow, any delay this long is not waited anyway
+		if seconds > math.MaxInt32 {
+			seconds = math.MaxInt32
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if d := date.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// rewindRequest returns a copy of the request with a fresh body.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = body
+	return req, nil
+}
+
+// sleepContext waits for the delay, or until the context is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		want       time.Duration
+		wantOK     bool
+	}{
+		{name: "seconds", status: http.StatusTooManyRequests, retryAfter: "3", want: 3 * time.Second, wantOK: true},
+		{name: "zero seconds", status: http.StatusServiceUnavailable, retryAfter: "0", want: 0, wantOK: true},
+		{name: "HTTP-date", status: http.StatusServiceUnavailable, retryAfter: now.Add(5 * time.Second).Format(http.TimeFormat), want: 5 * time.Second, wantOK: true},
+		{name: "HTTP-date in the past", status: http.StatusTooManyRequests, retryAfter: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOK: true},
+		{name: "missing header", status: http.StatusServiceUnavailable},
+		{name: "negative seconds", status: http.StatusTooManyRequests, retryAfter: "-1"},
+		{name: "invalid value", status: http.StatusTooManyRequests, retryAfter: "soon"},
+		{name: "other status", status: http.StatusOK, retryAfter: "3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			got, ok := retryAfter(resp, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// roundTripFunc is a RoundTripper calling a func.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestRetryTransportRetryAfter(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		retryAfter  string
+		wantStatus  int
+		wantAttempt int
+	}{
+		{name: "503 seconds", status: http.StatusServiceUnavailable, retryAfter: "0", wantStatus: http.StatusOK, wantAttempt: 2},
+		{name: "429 seconds", status: http.StatusTooManyRequests, retryAfter: "0", wantStatus: http.StatusOK, wantAttempt: 2},
+		{name: "429 HTTP-date", status: http.StatusTooManyRequests, retryAfter: time.Now().Add(-time.Minute).Format(http.TimeFormat), wantStatus: http.StatusOK, wantAttempt: 2},
+		{name: "429 without Retry-After", status: http.StatusTooManyRequests, wantStatus: http.StatusTooManyRequests, wantAttempt: 1},
+		{name: "Retry-After too long", status: http.StatusServiceUnavailable, retryAfter: "3600", wantStatus: http.StatusServiceUnavailable, wantAttempt: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			next := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				attempts++
+				resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}
+				if attempts == 1 {
+					resp.StatusCode = tt.status
+					if tt.retryAfter != "" {
+						resp.Header.Set("Retry-After", tt.retryAfter)
+					}
+				}
+				return resp, nil
+			})
+
+			// The backoff is longer than the test timeout, retries must use the Retry-After delay
+			transport := newRetryTransport(next, 3, time.Hour)
+			r, _ := http.NewRequest(http.MethodGet, "http://kubernetes/api/v1/pods", nil)
+			resp, err := transport.RoundTrip(r)
+			if err != nil {
+				t.Fatalf("RoundTrip() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if attempts != tt.wantAttempt {
+				t.Fatalf("attempts = %d, want %d", attempts, tt.wantAttempt)
+			}
+		})
+	}
+}
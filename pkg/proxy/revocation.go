@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// errTokenRevoked is returned for tokens in the revocation list.
+var errTokenRevoked = errors.New("token revoked")
+
+// RevocationList holds the ids of revoked tokens, a token id is the JWT jti claim
+// or the token hash returned by TokenHash.
+type RevocationList interface {
+	Revoke(tokenID string) error
+	IsRevoked(tokenID string) (bool, error)
+}
+
+// MemoryRevocationList is an in-memory RevocationList, revocations are lost when the process exits.
+type MemoryRevocationList struct {
+	mu      sync.RWMutex
+	revoked map[string]struct{}
+}
+
+// NewMemoryRevocationList creates an empty in-memory revocation list.
+func NewMemoryRevocationList() *MemoryRevocationList {
+	return &MemoryRevocationList{revoked: map[string]struct{}{}}
+}
+
+// Revoke adds a token id to the revocation list.
+func (l *MemoryRevocationList) Revoke(tokenID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.revoked[tokenID] = struct{}{}
+	return nil
+}
+
+// IsRevoked checks if a token id is in the revocation list.
+func (l *MemoryRevocationList) IsRevoked(tokenID string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.revoked[tokenID]
+	return ok, nil
+}
+
+// TokenHash returns the id of a raw token, used to revoke tokens without a jti claim.
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// revocationList returns the server revocation list, defaults to an in-memory list.
+func (s *Server) revocationList() RevocationList {
+	s.revocationOnce.Do(func() {
+		if s.RevocationList == nil {
+			s.RevocationList = NewMemoryRevocationList()
+		}
+	})
+
+	return s.RevocationList
+}
+
+// Revoke invalidates a token before it expires, tokenID is the JWT jti claim
+// or the token hash returned by TokenHash.
+func (s *Server) Revoke(tokenID string) error {
+	if tokenID == "" {
+		return fmt.Errorf("missing token id")
+	}
+
+	return s.revocationList().Revoke(tokenID)
+}
+
+// checkRevoked returns errTokenRevoked if the token jti claim or the token hash is revoked.
+func (s *Server) checkRevoked(token string, claims jwt.MapClaims) error {
+	ids := []string{TokenHash(token)}
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		ids = append(ids, jti)
+	}
+
+	for _, id := range ids {
+		revoked, err := s.revocationList().IsRevoked(id)
+		if err != nil {
+			return fmt.Errorf("fail to check token revocation: %+v", err)
+		}
+		if revoked {
+			return errTokenRevoked
+		}
+	}
+
+	return nil
+}
+
+// RevokeHandler returns a Handler func used by admins to revoke tokens, requests must
+// use the AdminToken as bearer token, and post a jti or a raw token to revoke.
+func (s *Server) RevokeHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Log request
+		w, done := s.startRequestLog(w, r, "revoke")
+		defer done()
+
+		if r.Method != http.MethodPost {
+			s.writeError(w, r, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			setBearerChallenge(w, "", "")
+			s.writeError(w, r, http.StatusUnauthorized, fmt.Errorf("no token received"))
+			return
+		}
+		if s.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			s.writeError(w, r, http.StatusForbidden, fmt.Errorf("admin token is not valid"))
+			return
+		}
+
+		tokenID := r.FormValue("jti")
+		if raw := r.FormValue("token"); raw != "" {
+			tokenID = TokenHash(raw)
+		}
+
+		if err := s.Revoke(tokenID); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, fmt.Errorf("fail to revoke token: %+v", err))
+			return
+		}
+
+		s.logger().Info("token revoked", append(s.requestAttrs(r), "token_id", tokenID)...)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
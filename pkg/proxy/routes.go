@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Route holds an upstream k8s API server served under a path prefix.
+type Route struct {
+	// APIServerURL is the upstream k8s API server URL.
+	APIServerURL string
+	// APITransport is used for upstream requests, defaults to the server APITransport.
+	APITransport *http.Transport
+	// BearerToken replaces valid JWT tokens for upstream requests, defaults to the server BearerToken.
+	BearerToken string
+	// UpstreamHost overrides the Host header and the TLS server name of upstream requests.
+	UpstreamHost string
+}
+
+// RoutesProxy return a Handler func that will proxy requests to the k8s API server
+// of the route with the longest matching path prefix, the prefix is removed before forwarding.
+func (s *Server) RoutesProxy() http.Handler {
+	proxies := map[string]http.Handler{}
+	for prefix, route := range s.Routes {
+		transport := route.APITransport
+		if transport == nil {
+			transport = s.APITransport
+		}
+
+		proxies[prefix] = s.newAPIProxy(prefix, route.APIServerURL, transport, route.UpstreamHost)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := s.routePrefix(r.URL.Path)
+		if prefix == "" {
+			s.writeError(w, r, http.StatusNotFound, fmt.Errorf("no route for path %s", r.URL.Path))
+			return
+		}
+
+		proxies[prefix].ServeHTTP(w, r)
+	})
+}
+
+// routePrefix returns the longest route prefix matching a path, or an empty string.
+func (s *Server) routePrefix(path string) string {
+	match := ""
+	for prefix := range s.Routes {
+		trimmed := strings.TrimSuffix(prefix, "/")
+		if (path == trimmed || strings.HasPrefix(path, trimmed+"/")) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+
+	return match
+}
+
+// routeFor returns the API path and operator bearer token used for a request path,
+// defaults to the server APIPath and BearerToken when no route matches.
+func (s *Server) routeFor(path string) (string, string) {
+	prefix := s.routePrefix(path)
+	if prefix == "" {
+		return s.APIPath, s.operatorToken()
+	}
+
+	bearerToken := s.Routes[prefix].BearerToken
+	if bearerToken == "" {
+		bearerToken = s.operatorToken()
+	}
+
+	return prefix, bearerToken
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultShutdownGracePeriod is the time in-flight requests have to complete on shutdown.
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// Run listens on addr and serves ServeMux, wrapped by ServeHandler, over HTTP until ctx is cancelled,
+// then shuts down gracefully, see RunTLS.
+func (s *Server) Run(ctx context.Context, addr string) error {
+	return s.run(ctx, addr, func(srv *http.Server, ln net.Listener) error {
+		return srv.Serve(ln)
+	})
+}
+
+// RunTLS listens on addr and serves ServeMux over HTTPS until ctx is cancelled.
+// On cancellation the server stops accepting connections and waits up to
+// ShutdownGracePeriod for in-flight requests and upgraded connections (e.g. exec
+// and attach streams) to complete, remaining connections are then closed.
+func (s *Server) RunTLS(ctx context.Context, addr string, certFile string, keyFile string) error {
+	return s.run(ctx, addr, func(srv *http.Server, ln net.Listener) error {
+		return srv.ServeTLS(ln, certFile, keyFile)
+	})
+}
+
+func (s *Server) run(ctx context.Context, addr string, serve func(*http.Server, net.Listener) error) error {
+	handler := http.Handler(s.ServeMux)
+	if s.ServeMux == nil {
+		handler = http.DefaultServeMux
+	}
+	handler = s.ServeHandler(handler)
+
+	conns := &hijackedConns{conns: map[net.Conn]struct{}{}}
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   handler,
+		ConnState: conns.track,
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.ProxyProtocol {
+		ln = &proxyProtocolListener{Listener: ln}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serve(srv, ln)
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	grace := s.ShutdownGracePeriod
+	if grace <= 0 {
+		grace = defaultShutdownGracePeriod
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	// Stop accepting connections and wait for in-flight requests
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	// Wait for upgraded connections, and force close all connections after the grace period
+	conns.wait(shutdownCtx)
+	conns.closeAll()
+	srv.Close()
+
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// hijackedConns tracks connections hijacked by upgrade requests,
+// http.Server.Shutdown does not wait for or close these connections.
+type hijackedConns struct {
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (h *hijackedConns) track(c net.Conn, state http.ConnState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if state == http.StateHijacked {
+		h.conns[c] = struct{}{}
+	}
+}
+
+// wait polls until all tracked connections are closed or ctx is done.
+func (h *hijackedConns) wait(ctx context.Context) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		h.prune()
+		if h.count() == 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune removes connections that were closed by the peer or the handler.
+func (h *hijackedConns) prune() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.conns {
+		// A closed connection fails to set a deadline
+		if err := c.SetReadDeadline(time.Time{}); err != nil {
+			delete(h.conns, c)
+		}
+	}
+}
+
+func (h *hijackedConns) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.conns)
+}
+
+func (h *hijackedConns) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.conns {
+		c.Close()
+		delete(h.conns, c)
+	}
+}
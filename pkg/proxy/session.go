@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// defaultSessionTTL is the time a stored session is kept when SessionMaxAge is not set.
+	defaultSessionTTL = 24 * time.Hour
+
+	// sessionIDLength is the number of random bytes used for a session id.
+	sessionIDLength = 32
+
+	// memorySessionStoreMaxEntries is the number of sessions kept by a MemorySessionStore,
+	// the oldest session is removed when full.
+	memorySessionStoreMaxEntries = 100000
+)
+
+// Session holds the tokens of a user session kept in a SessionStore.
+type Session struct {
+	// AccessToken is the token sent to the k8s API server.
+	AccessToken string `json:"accessToken"`
+	// OAuthToken is the OAuth2 token, including the refresh token, nil for tokens set
+	// manually using the token endpoint.
+	OAuthToken *oauth2.Token `json:"oauthToken,omitempty"`
+	// Fingerprint is the client fingerprint recorded at login, set when the Server
+	// BindSessionToClient is set.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// SessionStore keeps sessions by session id, when a Server uses a SessionStore the session
+// cookie holds only an opaque session id, so large tokens do not overflow the cookie size
+// limit. External stores (e.g. Redis) implement this interface, Get returns nil and no
+// error for unknown or expired sessions.
+type SessionStore interface {
+	Get(id string) (*Session, error)
+	Set(id string, session *Session, ttl time.Duration) error
+	Delete(id string) error
+}
+
+// MemorySessionStore is an in-memory SessionStore, sessions are lost when the process exits,
+// and are not shared between replicas. It keeps up to 100000 sessions, the oldest session
+// is removed when full.
+type MemorySessionStore struct {
+	sessions *memoryStore[Session]
+}
+
+// NewMemorySessionStore creates an empty in-memory session store.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: newMemoryStore(memorySessionStoreMaxEntries, cloneSession)}
+}
+
+// cloneSession returns a copy of a session, that does not share its OAuth2 token.
+func cloneSession(session Session) Session {
+	if session.OAuthToken != nil {
+		tok := *session.OAuthToken
+		session.OAuthToken = &tok
+	}
+	return session
+}
+
+// Get returns a copy of a session, or nil if the session is unknown or expired.
+func (m *MemorySessionStore) Get(id string) (*Session, error) {
+	return m.sessions.get(id), nil
+}
+
+// Set stores a copy of a session until ttl passes, expired sessions are removed.
+func (m *MemorySessionStore) Set(id string, session *Session, ttl time.Duration) error {
+	m.sessions.set(id, session, ttl)
+	return nil
+}
+
+// Delete removes a session.
+func (m *MemorySessionStore) Delete(id string) error {
+	m.sessions.delete(id)
+	return nil
+}
+
+// sessionTTL returns the time a stored session is kept.
+func (s *Server) sessionTTL() time.Duration {
+	if s.SessionMaxAge > 0 {
+		return s.SessionMaxAge
+	}
+	return defaultSessionTTL
+}
+
+// sessionID returns the session id from the signed session cookie, or an empty string.
+func (s *Server) sessionID(r *http.Request) string {
+	cookie, err := r.Cookie(s.sessionCookieName())
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+
+	id, err := verifyCookieValue(s.cookieKey(), cookie.Value)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
+// getSession returns the stored session of the request, or nil.
+func (s *Server) getSession(r *http.Request) (*Session, error) {
+	id := s.sessionID(r)
+	if id == "" {
+		return nil, nil
+	}
+
+	session, err := s.SessionStore.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get session: %+v", err)
+	}
+	return session, nil
+}
+
+// storeSession stores a session, and sets the session cookie holding the session id.
+func (s *Server) storeSession(w http.ResponseWriter, r *http.Request, id string, session *Session) error {
+	if err := s.SessionStore.Set(id, session, s.sessionTTL()); err != nil {
+		return fmt.Errorf("fail to store session: %+v", err)
+	}
+
+	cookie := s.newCookie(r, s.sessionCookieName(), signCookieValue(s.cookieKey(), id))
+	s.setCookieExpiry(cookie, time.Now().Add(s.sessionTTL()))
+	http.SetCookie(w, cookie)
+
+	return nil
+}
+
+// startSession starts a new session after a login, tok is nil for tokens set manually.
+// A new session id is always used, so a session id planted before login is never
+// authenticated (session fixation).
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, token string, tok *oauth2.Token) error {
+	if s.SessionStore == nil {
+		if tok == nil {
+			// A manual token can not be refreshed, remove any OAuth2 token from older sessions.
+			s.clearLoginCookie(w, r, s.oauthTokenCookieName())
+			s.setSessionBinding(w, r, token)
+			return s.setSessionCookie(w, r, token, time.Time{})
+		}
+
+		// Keep the full token, used to refresh the access token.
+		if err := s.setOAuthTokenCookie(w, r, tok); err != nil {
+			s.logRequestError(r, "fail to store oauth token", err)
+		}
+		s.setSessionBinding(w, r, token)
+		return s.setSessionCookie(w, r, token, tok.Expiry)
+	}
+
+	// Remove the previous session
+	if id := s.sessionID(r); id != "" {
+		if err := s.SessionStore.Delete(id); err != nil {
+			s.logRequestError(r, "fail to delete session", err)
+		}
+	}
+
+	id, err := randomString(sessionIDLength)
+	if err != nil {
+		return fmt.Errorf("fail to generate session id: %+v", err)
+	}
+
+	return s.storeSession(w, r, id, &Session{AccessToken: token, OAuthToken: tok, Fingerprint: s.sessionFingerprint(r)})
+}
+
+// updateSession replaces the session tokens after a token refresh.
+func (s *Server) updateSession(w http.ResponseWriter, r *http.Request, tok *oauth2.Token) error {
+	if s.SessionStore == nil {
+		if err := s.setOAuthTokenCookie(w, r, tok); err != nil {
+			return err
+		}
+		s.setSessionBinding(w, r, tok.AccessToken)
+		return s.setSessionCookie(w, r, tok.AccessToken, tok.Expiry)
+	}
+
+	id := s.sessionID(r)
+	if id == "" {
+		return fmt.Errorf("missing session id")
+	}
+
+	return s.storeSession(w, r, id, &Session{AccessToken: tok.AccessToken, OAuthToken: tok, Fingerprint: s.sessionFingerprint(r)})
+}
+
+// endSession removes the session, and clears the session cookies.
+func (s *Server) endSession(w http.ResponseWriter, r *http.Request) {
+	if s.SessionStore != nil {
+		if id := s.sessionID(r); id != "" {
+			if err := s.SessionStore.Delete(id); err != nil {
+				s.logRequestError(r, "fail to delete session", err)
+			}
+		}
+	}
+
+	s.clearSessionCookie(w, r)
+	s.clearLoginCookie(w, r, s.oauthTokenCookieName())
+	s.clearSessionBinding(w, r)
+}
+
+// sessionToken returns the session access token.
+func (s *Server) sessionToken(r *http.Request) (string, error) {
+	if s.SessionStore == nil {
+		return s.getSessionCookie(r)
+	}
+
+	session, err := s.getSession(r)
+	if err != nil || session == nil {
+		return "", err
+	}
+	return session.AccessToken, nil
+}
+
+// sessionOAuthToken returns the session OAuth2 token, used to refresh the access token.
+func (s *Server) sessionOAuthToken(r *http.Request) (*oauth2.Token, error) {
+	if s.SessionStore == nil {
+		return s.getOAuthTokenCookie(r)
+	}
+
+	session, err := s.getSession(r)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.OAuthToken == nil {
+		return nil, fmt.Errorf("missing oauth token")
+	}
+	return session.OAuthToken, nil
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// authRealm is the realm of the WWW-Authenticate Bearer challenge.
+const authRealm = "oc-proxy"
+
+// statusReasons maps HTTP status codes to Kubernetes Status reasons.
+var statusReasons = map[int]string{
+	http.StatusBadRequest:            "BadRequest",
+	http.StatusUnauthorized:          "Unauthorized",
+	http.StatusForbidden:             "Forbidden",
+	http.StatusNotFound:              "NotFound",
+	http.StatusMethodNotAllowed:      "MethodNotAllowed",
+	http.StatusRequestEntityTooLarge: "RequestEntityTooLarge",
+	http.StatusTooManyRequests:       "TooManyRequests",
+	http.StatusInternalServerError:   "InternalError",
+	http.StatusBadGateway:            "BadGateway",
+	http.StatusServiceUnavailable:    "ServiceUnavailable",
+	http.StatusGatewayTimeout:        "Timeout",
+}
+
+// status is a Kubernetes API Status object.
+type status struct {
+	Kind       string            `json:"kind"`
+	APIVersion string            `json:"apiVersion"`
+	Metadata   map[string]string `json:"metadata"`
+	API        string            `json:"api"`
+	Status     string            `json:"status"`
+	Message    string            `json:"message"`
+	Reason     string            `json:"reason"`
+	Code       int               `json:"code"`
+	RequestID  string            `json:"requestId,omitempty"`
+}
+
+// statusReason returns the Kubernetes Status reason for an HTTP status code.
+func statusReason(code int) string {
+	if reason, ok := statusReasons[code]; ok {
+		return reason
+	}
+	return strings.ReplaceAll(http.StatusText(code), " ", "")
+}
+
+// handleError writes a Kubernetes style Status error response with the given HTTP status code,
+// the response includes the request ID set by RequestIDMiddleware.
+func handleError(w http.ResponseWriter, code int, err error) {
+	b := statusBody(code, err, w.Header().Get(requestIDHeader))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// statusBody returns a Kubernetes style Status error.
+func statusBody(code int, err error, requestID string) []byte {
+	b, _ := json.Marshal(status{
+		Kind:       "Status",
+		APIVersion: "v1",
+		Metadata:   map[string]string{},
+		API:        "ocgate",
+		Status:     "Failure",
+		Message:    err.Error(),
+		Reason:     statusReason(code),
+		Code:       code,
+		RequestID:  requestID,
+	})
+	return b
+}
+
+// setBearerChallenge sets the WWW-Authenticate Bearer challenge (RFC 6750), errorCode
+// and description are optional, e.g. "invalid_token" for a rejected token.
+func setBearerChallenge(w http.ResponseWriter, errorCode string, description string) {
+	challenge := fmt.Sprintf("Bearer realm=%q", authRealm)
+	if errorCode != "" {
+		challenge += fmt.Sprintf(", error=%q", errorCode)
+	}
+	if description != "" {
+		challenge += fmt.Sprintf(", error_description=%q", strings.ReplaceAll(description, "\"", "'"))
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+}
+
+// proxyErrorHandler writes a Status error response when the k8s API server can not be reached.
+func (s *Server) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	s.logRequestError(r, "fail to proxy request", err)
+
+	// Request body exceeded MaxRequestBodyBytes while sent to the k8s API server
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large, limit is %d bytes", maxBytesErr.Limit))
+		return
+	}
+
+	// Wrapped errors are matched, e.g. a RequestTimeoutMiddleware deadline returned
+	// by the transport inside a *url.Error
+	var netErr net.Error
+	code := http.StatusBadGateway
+	switch {
+	case errors.Is(err, errCircuitOpen):
+		code = http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded):
+		code = http.StatusGatewayTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		code = http.StatusGatewayTimeout
+	}
+
+	s.writeError(w, r, code, fmt.Errorf("k8s API server error: %v", err))
+}
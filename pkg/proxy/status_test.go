@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestHandleError(t *testing.T) {
+	tests := []struct {
+		code       int
+		wantReason string
+	}{
+		{code: http.StatusBadRequest, wantReason: "BadRequest"},
+		{code: http.StatusUnauthorized, wantReason: "Unauthorized"},
+		{code: http.StatusForbidden, wantReason: "Forbidden"},
+		{code: http.StatusGatewayTimeout, wantReason: "Timeout"},
+		{code: http.StatusTeapot, wantReason: "I'mateapot"},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.code), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			w.Header().Set(requestIDHeader, "request-id")
+			handleError(w, tt.code, fmt.Errorf("failed"))
+
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d", w.Code, tt.code)
+			}
+			if got := w.Result().Header.Get("Content-Type"); got != "application/json" {
+				t.Fatalf("Content-Type = %q, want application/json", got)
+			}
+
+			var got status
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("fail to decode status: %v", err)
+			}
+			want := status{Kind: "Status", APIVersion: "v1", Metadata: map[string]string{}, API: "ocgate", Status: "Failure", Message: "failed", Reason: tt.wantReason, Code: tt.code, RequestID: "request-id"}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("status = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error timeout, e.g. a dial or TLS handshake timeout.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestProxyErrorHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "connection refused", err: errors.New("connection refused"), wantCode: http.StatusBadGateway},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, wantCode: http.StatusGatewayTimeout},
+		{name: "wrapped deadline exceeded", err: fmt.Errorf("round trip: %w", context.DeadlineExceeded), wantCode: http.StatusGatewayTimeout},
+		{name: "URL error timeout", err: &url.Error{Op: "Get", URL: "https://kubernetes", Err: timeoutError{}}, wantCode: http.StatusGatewayTimeout},
+		{name: "wrapped net timeout", err: fmt.Errorf("dial: %w", timeoutError{}), wantCode: http.StatusGatewayTimeout},
+		{name: "circuit open", err: errCircuitOpen, wantCode: http.StatusServiceUnavailable},
+		{name: "client canceled", err: context.Canceled, wantCode: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{}
+
+			w := httptest.NewRecorder()
+			s.proxyErrorHandler(w, httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil), tt.err)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+)
+
+// RequestTimeoutMiddleware limits the time a request may take, the request context is
+// cancelled after RequestTimeout and a proxied request that did not complete gets a
+// 504 Status response. Watch, log, exec and upgrade requests are long lived and not limited.
+//
+// http.TimeoutHandler is not used since it buffers the whole response, which breaks
+// flushing large and chunked responses, and it responds with 503 on timeout.
+func (s *Server) RequestTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.RequestTimeout <= 0 || isUpgradeRequest(r) || isStreamingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), s.RequestTimeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
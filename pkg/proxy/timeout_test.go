@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestTimeout(t *testing.T) {
+	const (
+		requestTimeout = 100 * time.Millisecond
+		upstreamDelay  = 500 * time.Millisecond
+	)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(upstreamDelay):
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name        string
+		path        string
+		wantStatus  int
+		wantTimeout bool
+	}{
+		{name: "slow upstream", path: "/k8s/api/v1/pods", wantStatus: http.StatusGatewayTimeout, wantTimeout: true},
+		{name: "watch request is not limited", path: "/k8s/api/v1/pods?watch=true", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIPath: "/k8s/", APIServerURL: upstream.URL, RequestTimeout: requestTimeout}
+
+			start := time.Now()
+			w := httptest.NewRecorder()
+			s.RequestTimeoutMiddleware(s.APIProxy()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			elapsed := time.Since(start)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantTimeout && elapsed >= upstreamDelay {
+				t.Fatalf("request took %v, want it to end after the %v request timeout", elapsed, requestTimeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// upstreamCAs returns the CA pool used to verify the k8s API server certificate,
+// UpstreamCAs is used when set, otherwise the UpstreamCAFile is read once.
+// A nil pool means the system roots are used.
+func (s *Server) upstreamCAs() (*x509.CertPool, error) {
+	if s.UpstreamCAs != nil {
+		return s.UpstreamCAs, nil
+	}
+
+	s.upstreamCAsOnce.Do(func() {
+		if s.UpstreamCAFile != "" {
+			s.upstreamCAPool, s.upstreamCAErr = readCAFile(s.UpstreamCAFile)
+		}
+	})
+
+	return s.upstreamCAPool, s.upstreamCAErr
+}
+
+// readCAFile reads a PEM encoded CA bundle.
+func readCAFile(filename string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("fail to read CA file: %+v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA found in file %s", filename)
+	}
+
+	return pool, nil
+}
@@ -1,28 +1,197 @@
 package proxy
 
 import (
-	"crypto/rsa"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	ocgatev1beta1 "github.com/yaacov/oc-gate-operator/api/v1beta1"
 )
 
-func authenticateToken(token string, secret []byte, publicKey *rsa.PublicKey) (*jwt.Token, error) {
-	tok, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
-			return secret, nil
+var (
+	// errTokenExpired is returned for tokens used after the exp claim.
+	errTokenExpired = errors.New("token expired")
+	// errTokenNotValidYet is returned for tokens used before the nbf claim.
+	errTokenNotValidYet = errors.New("token not valid yet")
+	// errTokenSignature is returned for tokens with a signature that does not match the key.
+	errTokenSignature = errors.New("token signature is not valid")
+	// errTokenAudience is returned for tokens issued for a different audience.
+	errTokenAudience = errors.New("token audience is not valid")
+	// errTokenIssuer is returned for tokens issued by an unexpected issuer.
+	errTokenIssuer = errors.New("token issuer is not valid")
+	// errTokenAlgorithm is returned for tokens signed with an algorithm that has no configured key.
+	errTokenAlgorithm = errors.New("token signing algorithm is not allowed")
+	// errTokenUnsigned is returned for unsigned tokens, e.g. tokens using the "none" algorithm.
+	errTokenUnsigned = errors.New("unsigned token is not allowed")
+)
+
+// validateToken authenticates a JWT token and validates its time claims,
+// allowing ClockSkew leeway, and returns the token claims. Tokens issued by one
+// of the Providers are verified using the provider keys.
+func (s *Server) validateToken(token string) (jwt.MapClaims, error) {
+	keyFunc := s.tokenKey
+	provider := s.tokenProvider(token)
+	if provider != nil {
+		keyFunc = func(t *jwt.Token) (interface{}, error) {
+			return s.providerTokenKey(provider, t)
 		}
+	}
+
+	jwtToken, err := authenticateToken(token, keyFunc)
+	if ve, ok := err.(*jwt.ValidationError); ok && ve.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+		return nil, errTokenSignature
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token invalid: %v", err)
+	}
+	if !jwtToken.Valid {
+		return nil, fmt.Errorf("token invalid")
+	}
+
+	// Get token claims
+	claims, ok := jwtToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("token invalid: claims are not valid")
+	}
+
+	// Validate token time claims
+	now := time.Now()
+	if !claims.VerifyExpiresAt(now.Add(-s.ClockSkew).Unix(), false) {
+		return nil, errTokenExpired
+	}
+	if !claims.VerifyNotBefore(now.Add(s.ClockSkew).Unix(), false) {
+		return nil, errTokenNotValidYet
+	}
+
+	// Validate token audience
+	if s.ExpectedAudience != "" && !contains(claimStrings(claims, "aud"), s.ExpectedAudience) {
+		return nil, errTokenAudience
+	}
 
-		if _, ok := t.Method.(*jwt.SigningMethodRSA); ok {
-			return publicKey, nil
+	// Validate token issuer, provider tokens are selected by their issuer
+	if provider == nil {
+		if err := s.validateIssuer(claims); err != nil {
+			return nil, err
 		}
+	}
 
-		return nil, fmt.Errorf("failed to parse token signing")
-	})
+	// Check token revocation
+	if err := s.checkRevoked(token, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// tokenFailureReason categorizes a validateToken error.
+func tokenFailureReason(err error) string {
+	switch err {
+	case errTokenExpired:
+		return "expired-token"
+	case errTokenNotValidYet:
+		return "not-yet-valid-token"
+	case errTokenSignature:
+		return "bad-signature"
+	case errTokenAudience:
+		return "wrong-audience"
+	case errTokenIssuer:
+		return "wrong-issuer"
+	case errTokenRevoked:
+		return "revoked-token"
+	default:
+		return "invalid-token"
+	}
+}
+
+// validateIssuer checks the token iss claim matches the expected issuer.
+// When ExpectedIssuer is set the iss claim is required, when defaulting to
+// IssuerEndpoint tokens without an iss claim (e.g. gate tokens) are allowed.
+func (s *Server) validateIssuer(claims jwt.MapClaims) error {
+	expected := s.ExpectedIssuer
+	required := expected != ""
+	if expected == "" {
+		expected = s.IssuerEndpoint
+	}
+	if expected == "" {
+		return nil
+	}
 
-	return tok, err
+	issuer, ok := claims["iss"].(string)
+	if !ok || issuer == "" {
+		if required {
+			return errTokenIssuer
+		}
+		return nil
+	}
+
+	if strings.TrimSuffix(issuer, "/") != strings.TrimSuffix(expected, "/") {
+		return errTokenIssuer
+	}
+
+	return nil
+}
+
+// claimStrings returns a claim that may be a single string or an array of strings.
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// tokenKey returns the key used to verify a JWT token signature, selected by the token
+// alg header: HS256/384/512 tokens use JWTTokenKey, RS256/384/512 tokens use the JWKS key
+// matching the token key id when JWKSURL is set, or JWTTokenRSAKey. Tokens signed with an
+// algorithm that has no configured key are rejected, e.g. an HS256 token when only an RSA
+// key is configured, so an RSA public key can not be used as an HMAC secret.
+func (s *Server) tokenKey(t *jwt.Token) (interface{}, error) {
+	config := s.current()
+	switch t.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(config.JWTTokenKey) > 0 {
+			return config.JWTTokenKey, nil
+		}
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		if config.JWKSURL != "" {
+			kid, _ := t.Header["kid"].(string)
+			return config.jwks.key(kid)
+		}
+
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); ok && config.JWTTokenRSAKey != nil {
+			return config.JWTTokenRSAKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w (%v)", errTokenAlgorithm, t.Header["alg"])
+}
+
+// authenticateToken parses a JWT token and verifies its signature,
+// time claims are validated by validateToken. Unsigned tokens are always
+// rejected, whatever key the key func returns.
+func authenticateToken(token string, keyFunc jwt.Keyfunc) (*jwt.Token, error) {
+	if parts := strings.Split(token, "."); len(parts) == 3 && parts[2] == "" {
+		return nil, errTokenUnsigned
+	}
+
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	return parser.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		if alg, _ := t.Header["alg"].(string); strings.EqualFold(alg, "none") || t.Method == jwt.SigningMethodNone {
+			return nil, errTokenUnsigned
+		}
+		return keyFunc(t)
+	})
 }
 
 func getTokenData(claims jwt.MapClaims) *ocgatev1beta1.GateToken {
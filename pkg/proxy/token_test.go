@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var testJWTKey = []byte("test-jwt-key")
+
+func signHS256(t *testing.T, key []byte, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("fail to sign token: %v", err)
+	}
+	return token
+}
+
+// isTokenError checks a validateToken error is target, parse errors are formatted
+// into a "token invalid" error, so they are matched by message.
+func isTokenError(err error, target error) bool {
+	return err != nil && (errors.Is(err, target) || strings.Contains(err.Error(), target.Error()))
+}
+
+func TestValidateTokenUnsigned(t *testing.T) {
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "user"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "none algorithm", token: unsigned},
+		{name: "none algorithm with signature", token: unsigned + "c2lnbmF0dXJl"},
+		{name: "HS256 without signature", token: strings.Join(strings.Split(signHS256(t, testJWTKey, jwt.MapClaims{"sub": "user"}), ".")[:2], ".") + "."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{JWTTokenKey: testJWTKey}
+			if _, err := s.validateToken(tt.token); !isTokenError(err, errTokenUnsigned) {
+				t.Fatalf("validateToken() error = %v, want %v", err, errTokenUnsigned)
+			}
+		})
+	}
+}
+
+func TestValidateTokenAlgorithm(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("fail to generate RSA key: %v", err)
+	}
+	publicKeyDER, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("fail to marshal RSA key: %v", err)
+	}
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyDER})
+
+	rs256, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "user"}).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("fail to sign token: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		server  *Server
+		token   string
+		wantErr bool
+	}{
+		{
+			name:   "HS256 with HMAC key",
+			server: &Server{JWTTokenKey: testJWTKey},
+			token:  signHS256(t, testJWTKey, jwt.MapClaims{"sub": "user"}),
+		},
+		{
+			name:   "RS256 with RSA key",
+			server: &Server{JWTTokenRSAKey: &privateKey.PublicKey},
+			token:  rs256,
+		},
+		{
+			name:   "RS256 with both keys",
+			server: &Server{JWTTokenKey: testJWTKey, JWTTokenRSAKey: &privateKey.PublicKey},
+			token:  rs256,
+		},
+		{
+			name:    "RS256 with HMAC key only",
+			server:  &Server{JWTTokenKey: testJWTKey},
+			token:   rs256,
+			wantErr: true,
+		},
+		{
+			name:    "HS256 signed with the RSA public key PEM",
+			server:  &Server{JWTTokenRSAKey: &privateKey.PublicKey},
+			token:   signHS256(t, publicKeyPEM, jwt.MapClaims{"sub": "user"}),
+			wantErr: true,
+		},
+		{
+			name:    "HS256 signed with the RSA public key DER",
+			server:  &Server{JWTTokenRSAKey: &privateKey.PublicKey},
+			token:   signHS256(t, publicKeyDER, jwt.MapClaims{"sub": "user"}),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.server.validateToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !isTokenError(err, errTokenAlgorithm) {
+				t.Fatalf("validateToken() error = %v, want %v", err, errTokenAlgorithm)
+			}
+		})
+	}
+}
+
+func TestValidateTokenTimeClaims(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		claims    jwt.MapClaims
+		clockSkew time.Duration
+		wantErr   error
+	}{
+		{name: "no time claims", claims: jwt.MapClaims{}},
+		{name: "not expired", claims: jwt.MapClaims{"exp": now.Add(time.Minute).Unix()}},
+		{name: "expired", claims: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, wantErr: errTokenExpired},
+		{name: "expired within clock skew", claims: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, clockSkew: 2 * time.Minute},
+		{name: "expired beyond clock skew", claims: jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}, clockSkew: 30 * time.Second, wantErr: errTokenExpired},
+		{name: "valid after nbf", claims: jwt.MapClaims{"nbf": now.Add(-time.Minute).Unix()}},
+		{name: "not valid yet", claims: jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}, wantErr: errTokenNotValidYet},
+		{name: "not valid yet within clock skew", claims: jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}, clockSkew: 2 * time.Minute},
+		{name: "not valid yet beyond clock skew", claims: jwt.MapClaims{"nbf": now.Add(time.Minute).Unix()}, clockSkew: 30 * time.Second, wantErr: errTokenNotValidYet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{JWTTokenKey: testJWTKey, ClockSkew: tt.clockSkew}
+
+			_, err := s.validateToken(signHS256(t, testJWTKey, tt.claims))
+			if err != tt.wantErr {
+				t.Fatalf("validateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTokenAudience(t *testing.T) {
+	tests := []struct {
+		name             string
+		expectedAudience string
+		claims           jwt.MapClaims
+		wantErr          error
+	}{
+		{name: "no expected audience", claims: jwt.MapClaims{"aud": "other"}},
+		{name: "audience string", expectedAudience: "gateway", claims: jwt.MapClaims{"aud": "gateway"}},
+		{name: "audience array", expectedAudience: "gateway", claims: jwt.MapClaims{"aud": []interface{}{"other", "gateway"}}},
+		{name: "wrong audience", expectedAudience: "gateway", claims: jwt.MapClaims{"aud": "other"}, wantErr: errTokenAudience},
+		{name: "wrong audience array", expectedAudience: "gateway", claims: jwt.MapClaims{"aud": []interface{}{"other"}}, wantErr: errTokenAudience},
+		{name: "missing audience", expectedAudience: "gateway", claims: jwt.MapClaims{}, wantErr: errTokenAudience},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{JWTTokenKey: testJWTKey, ExpectedAudience: tt.expectedAudience}
+
+			_, err := s.validateToken(signHS256(t, testJWTKey, tt.claims))
+			if err != tt.wantErr {
+				t.Fatalf("validateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTokenIssuer(t *testing.T) {
+	tests := []struct {
+		name           string
+		expectedIssuer string
+		issuerEndpoint string
+		claims         jwt.MapClaims
+		wantErr        error
+	}{
+		{name: "no expected issuer", claims: jwt.MapClaims{"iss": "https://other"}},
+		{name: "issuer", expectedIssuer: "https://issuer", claims: jwt.MapClaims{"iss": "https://issuer"}},
+		{name: "issuer trailing slash", expectedIssuer: "https://issuer", claims: jwt.MapClaims{"iss": "https://issuer/"}},
+		{name: "wrong issuer", expectedIssuer: "https://issuer", claims: jwt.MapClaims{"iss": "https://other"}, wantErr: errTokenIssuer},
+		{name: "missing required issuer", expectedIssuer: "https://issuer", claims: jwt.MapClaims{}, wantErr: errTokenIssuer},
+		{name: "issuer endpoint", issuerEndpoint: "https://issuer", claims: jwt.MapClaims{"iss": "https://issuer"}},
+		{name: "issuer endpoint missing issuer", issuerEndpoint: "https://issuer", claims: jwt.MapClaims{}},
+		{name: "issuer endpoint wrong issuer", issuerEndpoint: "https://issuer", claims: jwt.MapClaims{"iss": "https://other"}, wantErr: errTokenIssuer},
+		{name: "expected issuer overrides issuer endpoint", expectedIssuer: "https://issuer", issuerEndpoint: "https://other", claims: jwt.MapClaims{"iss": "https://other"}, wantErr: errTokenIssuer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{JWTTokenKey: testJWTKey, ExpectedIssuer: tt.expectedIssuer, IssuerEndpoint: tt.issuerEndpoint}
+
+			_, err := s.validateToken(signHS256(t, testJWTKey, tt.claims))
+			if err != tt.wantErr {
+				t.Fatalf("validateToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBearerTokenReloadInterval is the time a bearer token read from a file is
+// used before reading the file again, projected service account tokens rotate on disk.
+const defaultBearerTokenReloadInterval = time.Minute
+
+// tokenFile reads a token from a file, and reads it again when the token is stale.
+type tokenFile struct {
+	path     string
+	interval time.Duration
+
+	mu    sync.Mutex
+	token string
+	read  time.Time
+}
+
+// get returns the file token, reading the file when the token is stale. When the file
+// can not be read the last token is used until the next reload, and the error is returned.
+func (f *tokenFile) get() (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.token != "" && time.Since(f.read) < f.interval {
+		return f.token, nil
+	}
+	f.read = time.Now()
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return f.token, fmt.Errorf("fail to read bearer token file: %+v", err)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return f.token, fmt.Errorf("bearer token file (%s) is empty", f.path)
+	}
+
+	f.token = token
+	return f.token, nil
+}
+
+// expire marks the token stale, the file is read again on the next get.
+func (f *tokenFile) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.read = time.Time{}
+}
+
+// bearerTokenFile returns the server bearer token file reader.
+func (s *Server) bearerTokenFile() *tokenFile {
+	s.bearerTokenFileOnce.Do(func() {
+		interval := s.BearerTokenReloadInterval
+		if interval <= 0 {
+			interval = defaultBearerTokenReloadInterval
+		}
+
+		s.tokenFile = &tokenFile{
+			path:     s.BearerTokenFile,
+			interval: interval,
+		}
+	})
+
+	return s.tokenFile
+}
+
+// operatorToken returns the operator token used for k8s API calls, read from
+// BearerTokenFile when set, otherwise BearerToken.
+func (s *Server) operatorToken() string {
+	if s.BearerTokenFile == "" {
+		return s.current().BearerToken
+	}
+
+	token, err := s.bearerTokenFile().get()
+	if err != nil {
+		s.logger().Error("fail to reload bearer token", "file", s.BearerTokenFile, "error", err)
+	}
+	return token
+}
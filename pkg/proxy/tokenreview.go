@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	authenticationv1 "k8s.io/api/authentication/v1"
+)
+
+const (
+	// defaultTokenReviewCacheTTL is the time an authenticated token review is cached
+	// when TokenReviewCacheTTL is not set.
+	defaultTokenReviewCacheTTL = 10 * time.Second
+
+	// tokenReviewTimeout is the timeout of a TokenReview request.
+	tokenReviewTimeout = 10 * time.Second
+
+	// tokenReviewPath is the k8s API path of the TokenReview resource.
+	tokenReviewPath = "/apis/authentication.k8s.io/v1/tokenreviews"
+)
+
+// tokenReviewVerbs are the claimed verbs of reviewed tokens, requests are authorized by
+// the k8s API server RBAC of the impersonated user.
+var tokenReviewVerbs = []interface{}{"get", "create", "update", "patch", "delete"}
+
+// reviewToken authenticates a token by posting a TokenReview to the k8s API server using
+// the operator credentials. The returned claims hold the user name as sub, and the user
+// groups, and allow all verbs.
+func (s *Server) reviewToken(ctx context.Context, token string) (jwt.MapClaims, error) {
+	review := authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	review.APIVersion = "authentication.k8s.io/v1"
+	review.Kind = "TokenReview"
+	if s.ExpectedAudience != "" {
+		review.Spec.Audiences = []string{s.ExpectedAudience}
+	}
+
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.APIServerURL, "/")+tokenReviewPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if bearerToken := s.operatorToken(); bearerToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bearerToken))
+	}
+
+	resp, err := s.tokenReviewClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fail to review token: %+v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to review token: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&review); err != nil {
+		return nil, fmt.Errorf("fail to parse token review: %+v", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, fmt.Errorf("token invalid: %s", review.Status.Error)
+		}
+		return nil, fmt.Errorf("token invalid")
+	}
+
+	groups := make([]interface{}, len(review.Status.User.Groups))
+	for i, group := range review.Status.User.Groups {
+		groups[i] = group
+	}
+	claims := jwt.MapClaims{
+		"sub":       review.Status.User.Username,
+		"uid":       review.Status.User.UID,
+		"groups":    groups,
+		"namespace": "*",
+		"verbs":     tokenReviewVerbs,
+		"apiGroups": []interface{}{"*"},
+	}
+
+	return claims, nil
+}
+
+// tokenReviewClient returns the HTTP client used for TokenReview requests, using the
+// same TLS configuration and credentials as proxied requests.
+func (s *Server) tokenReviewClient() *http.Client {
+	s.tokenReviewOnce.Do(func() {
+		s.tokenReviewHTTPClient = &http.Client{
+			Transport: s.proxyTransport(s.APIServerURL, s.APITransport, s.UpstreamHost),
+			Timeout:   tokenReviewTimeout,
+		}
+	})
+
+	return s.tokenReviewHTTPClient
+}
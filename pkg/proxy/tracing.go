@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracePropagator reads and writes the W3C trace context headers, e.g. traceparent.
+var tracePropagator = propagation.TraceContext{}
+
+// noopTracer is used when the Server Tracer is not set.
+var noopTracer = trace.NewNoopTracerProvider().Tracer("")
+
+// TracingMiddleware starts a server span for each request when Tracer is set, continuing
+// the trace of the incoming traceparent header. The span records the response status code,
+// and is marked as failed on 5xx responses.
+func (s *Server) TracingMiddleware(next http.Handler) http.Handler {
+	if s.Tracer == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := tracePropagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := s.Tracer.Start(ctx, "kube-gateway.request",
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest("kube-gateway", "", r)...))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(rec.Status())...)
+		if rec.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.Status()))
+		}
+	})
+}
+
+// startSpan starts an internal span using Tracer, or a no-op span when Tracer is not set.
+func (s *Server) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if s.Tracer == nil {
+		return noopTracer.Start(ctx, name)
+	}
+	return s.Tracer.Start(ctx, name)
+}
+
+// endSpan records the error of an operation, and ends its span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingTransport starts a client span for each request to the k8s API server, and
+// propagates the trace context using the traceparent header. The span ends when the
+// response headers are received, streamed response bodies are not included.
+type tracingTransport struct {
+	next   http.RoundTripper
+	tracer trace.Tracer
+}
+
+// RoundTrip sends the request in a client span.
+func (t *tracingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(r.Context(), "kube-gateway.upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPClientAttributesFromHTTPRequest(r)...))
+
+	// Round trippers must not modify the request, send a copy with the trace context
+	r = r.Clone(ctx)
+	tracePropagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+	resp, err := t.next.RoundTrip(r)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+	span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.StatusCode))
+	span.End()
+
+	return resp, nil
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultMaxIdleConns is the max number of idle connections to all hosts.
+	defaultMaxIdleConns = 100
+
+	// defaultMaxIdleConnsPerHost is the max number of idle connections kept to a host,
+	// proxied requests mostly go to a single k8s API server.
+	defaultMaxIdleConnsPerHost = 100
+
+	// defaultIdleConnTimeout is the time an idle connection is kept open.
+	defaultIdleConnTimeout = 90 * time.Second
+
+	// defaultDialTimeout is the timeout of establishing a TCP connection.
+	defaultDialTimeout = 30 * time.Second
+
+	// defaultKeepAlive is the TCP keep-alive period of open connections.
+	defaultKeepAlive = 30 * time.Second
+
+	// defaultTLSHandshakeTimeout is the timeout of a TLS handshake.
+	defaultTLSHandshakeTimeout = 10 * time.Second
+)
+
+// APITransportOptions configures a transport created by NewAPITransport, zero values
+// use the defaults.
+type APITransportOptions struct {
+	// MaxIdleConns is the max number of idle connections to all hosts, defaults to 100.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the max number of idle connections kept to a host,
+	// defaults to 100.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is the time an idle connection is kept open, defaults to 90s.
+	IdleConnTimeout time.Duration
+	// DialTimeout is the timeout of establishing a TCP connection, defaults to 30s.
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period of open connections, defaults to 30s.
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout is the timeout of a TLS handshake, defaults to 10s.
+	TLSHandshakeTimeout time.Duration
+
+	// RootCAs if set, are used to verify the server certificate instead of the system CAs.
+	RootCAs *x509.CertPool
+	// InsecureSkipVerify skips the server certificate verification.
+	InsecureSkipVerify bool
+
+	// DisableHTTP2 uses HTTP/1.1 only, by default HTTP/2 is negotiated when the
+	// server supports it.
+	DisableHTTP2 bool
+}
+
+// NewAPITransport creates a transport suitable for proxying requests to a k8s API server,
+// and for requests to the OAuth2 server. Idle connections are pooled and kept alive, so
+// consecutive requests reuse connections, and HTTP/2 is negotiated unless disabled.
+// Proxy settings are read from the environment. HTTP/2 connections can not carry upgrade
+// requests other than WebSocket, e.g. SPDY exec requests, the Server proxy sends upgrade
+// requests using an HTTP/1.1 copy of the transport.
+func NewAPITransport(opts APITransportOptions) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   durationOrDefault(opts.DialTimeout, defaultDialTimeout),
+		KeepAlive: durationOrDefault(opts.KeepAlive, defaultKeepAlive),
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          intOrDefault(opts.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   intOrDefault(opts.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		IdleConnTimeout:       durationOrDefault(opts.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   durationOrDefault(opts.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			RootCAs:            opts.RootCAs,
+			InsecureSkipVerify: opts.InsecureSkipVerify,
+		},
+		ForceAttemptHTTP2: true,
+	}
+
+	if opts.DisableHTTP2 {
+		disableHTTP2(transport)
+	}
+
+	return transport
+}
+
+// disableHTTP2 makes a transport use HTTP/1.1 only, HTTP/2 is not advertised in the TLS
+// handshake, so servers supporting it still answer using HTTP/1.1.
+func disableHTTP2(transport *http.Transport) {
+	transport.ForceAttemptHTTP2 = false
+
+	// A non-nil empty map disables the HTTP/2 upgrade of TLS connections
+	transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	if transport.TLSClientConfig != nil {
+		transport.TLSClientConfig.NextProtos = withoutHTTP2(transport.TLSClientConfig.NextProtos)
+	}
+}
+
+// withoutHTTP2 returns the ALPN protocols without HTTP/2.
+func withoutHTTP2(protos []string) []string {
+	var filtered []string
+	for _, proto := range protos {
+		if proto != "h2" {
+			filtered = append(filtered, proto)
+		}
+	}
+	return filtered
+}
+
+// upgradeTransport sends upgrade requests, e.g. SPDY and WebSocket exec requests, using an
+// HTTP/1.1 transport, and other requests using the next transport. The HTTP/2 transport
+// rejects requests with an Upgrade header other than websocket.
+type upgradeTransport struct {
+	next    http.RoundTripper
+	upgrade http.RoundTripper
+}
+
+// RoundTrip sends the request using the transport matching its upgrade header.
+func (t *upgradeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if isUpgradeRequest(r) {
+		return t.upgrade.RoundTrip(r)
+	}
+	return t.next.RoundTrip(r)
+}
+
+// intOrDefault returns n, or def if n is not positive.
+func intOrDefault(n int, def int) int {
+	if n > 0 {
+		return n
+	}
+	return def
+}
+
+// durationOrDefault returns d, or def if d is not positive.
+func durationOrDefault(d time.Duration, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
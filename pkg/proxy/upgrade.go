@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// isUpgradeRequest checks if a request asks to switch protocols,
+// e.g. WebSocket or SPDY requests used by exec, attach and port-forward.
+func isUpgradeRequest(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+// checkWebSocketOrigin returns an error for upgrade requests sent by a browser from an
+// origin that is not allowed, preventing cross-site WebSocket hijacking using the session
+// cookie. Requests without an Origin header, e.g. kubectl, are allowed. When
+// AllowedWebSocketOrigins is empty only the same origin is allowed.
+func (s *Server) checkWebSocketOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" || !isUpgradeRequest(r) {
+		return nil
+	}
+
+	if len(s.AllowedWebSocketOrigins) == 0 {
+		if strings.EqualFold(origin, s.requestScheme(r)+"://"+s.requestHost(r)) {
+			return nil
+		}
+	}
+	for _, allowed := range s.AllowedWebSocketOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("upgrade request origin %s is not allowed", origin)
+}
+
+// isStreamingRequest checks if a request is a k8s streaming request, e.g. watch,
+// log and exec requests, that expect each chunk to be sent without buffering.
+func isStreamingRequest(r *http.Request) bool {
+	q := r.URL.Query()
+	if q.Get("watch") == "true" || q.Get("watch") == "1" || q.Get("follow") == "true" {
+		return true
+	}
+
+	// Pod log, exec, attach and port-forward sub resources
+	for _, sub := range []string{"/log", "/exec", "/attach", "/portforward"} {
+		if strings.HasSuffix(strings.TrimSuffix(r.URL.Path, "/"), sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// headerHasToken checks if a comma separated header value contains a token.
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeAPIPath returns the API path with a leading and a trailing slash,
+// e.g. "k8s", "/k8s" and "k8s/" are all normalized to "/k8s/".
+func normalizeAPIPath(apiPath string) string {
+	if p := strings.Trim(apiPath, "/"); p != "" {
+		return "/" + p + "/"
+	}
+	return "/"
+}
+
+// hasAPIPath checks if the request URL path is under the API path.
+func hasAPIPath(r *http.Request, apiPath string) bool {
+	prefix := strings.TrimSuffix(normalizeAPIPath(apiPath), "/")
+
+	return r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")
+}
+
+// trimAPIPath removes the API path prefix from the request URL path, keeping the
+// leading slash, and the query string untouched, e.g. with the API path "/k8s/",
+// "/k8s/api/v1/pods?watch=1" is rewritten as "/api/v1/pods?watch=1" and "/k8s" as "/".
+func trimAPIPath(r *http.Request, apiPath string) {
+	prefix := strings.TrimSuffix(normalizeAPIPath(apiPath), "/")
+
+	r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	if r.URL.Path == "" {
+		r.URL.Path = "/"
+	}
+	if r.URL.RawPath != "" {
+		r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	}
+}
+
+// webSocketProtocolHeader lists the WebSocket subprotocols requested by the client,
+// e.g. "v4.channel.k8s.io", the upstream response echoes the negotiated subprotocol.
+const webSocketProtocolHeader = "Sec-WebSocket-Protocol"
+
+// webSocketBearerProtocolPrefix is the subprotocol prefix used by k8s WebSocket clients
+// to send a base64url encoded bearer token, browsers can not set an Authorization header.
+const webSocketBearerProtocolPrefix = "base64url.bearer.authorization.k8s.io."
+
+// webSocketProtocols returns the subprotocols requested by an upgrade request.
+func webSocketProtocols(r *http.Request) []string {
+	protocols := []string{}
+	for _, value := range r.Header.Values(webSocketProtocolHeader) {
+		for _, p := range strings.Split(value, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+// webSocketToken returns the bearer token sent as a WebSocket subprotocol, or an empty string.
+func webSocketToken(r *http.Request) string {
+	if !isUpgradeRequest(r) {
+		return ""
+	}
+
+	for _, p := range webSocketProtocols(r) {
+		if encoded := strings.TrimPrefix(p, webSocketBearerProtocolPrefix); encoded != p {
+			token, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+			if err != nil {
+				return ""
+			}
+			return string(token)
+		}
+	}
+
+	return ""
+}
+
+// removeWebSocketToken removes the bearer token subprotocol, other subprotocols are
+// forwarded to the k8s API server, which selects one of them.
+func removeWebSocketToken(r *http.Request) {
+	if r.Header.Get(webSocketProtocolHeader) == "" {
+		return
+	}
+
+	protocols := []string{}
+	for _, p := range webSocketProtocols(r) {
+		if !strings.HasPrefix(p, webSocketBearerProtocolPrefix) {
+			protocols = append(protocols, p)
+		}
+	}
+
+	if len(protocols) == 0 {
+		r.Header.Del(webSocketProtocolHeader)
+		return
+	}
+	r.Header.Set(webSocketProtocolHeader, strings.Join(protocols, ", "))
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// handleUpstreamUnauthorized replaces a 401 response of the k8s API server, that rejected
+// the credentials sent by the proxy, with a response explaining which credentials failed.
+// When the client token is passed through, browser clients using interactive authentication
+// are redirected to log in again, other clients get a 401 Status. When the operator bearer
+// token or client certificate is used, logging in again does not help, clients get a 502
+// Status explaining the operator token may have expired.
+// 403 responses are returned unchanged, they are RBAC decisions of authenticated requests.
+func (s *Server) handleUpstreamUnauthorized(resp *http.Response) {
+	if resp.StatusCode != http.StatusUnauthorized || resp.Request == nil {
+		return
+	}
+	r := resp.Request
+
+	// The original request, the proxied request path is rewritten to the k8s API path
+	original := r.Clone(r.Context())
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		original.URL = u
+	}
+
+	if s.BearerTokenPassthrough {
+		s.Metrics.authFailure("upstream-token-rejected")
+
+		if s.InteractiveAuth && s.LoginEndpoint != "" && prefersHTML(r) && !isUpgradeRequest(r) {
+			replaceResponse(resp, http.StatusTemporaryRedirect, nil)
+			resp.Header.Set("Location", s.loginURL(original))
+			return
+		}
+
+		replaceResponse(resp, http.StatusUnauthorized, fmt.Errorf("token rejected by the k8s API server, the token may have expired"))
+		return
+	}
+
+	s.Metrics.authFailure("upstream-operator-token-rejected")
+	s.logRequestError(original, "k8s API server rejected the operator credentials", fmt.Errorf("upstream status %d", resp.StatusCode))
+
+	replaceResponse(resp, http.StatusBadGateway, fmt.Errorf("k8s API server rejected the gateway credentials, the operator bearer token may have expired"))
+}
+
+// replaceResponse replaces the response status and body with a Status error, or with an
+// empty body if err is nil.
+func replaceResponse(resp *http.Response, code int, err error) {
+	resp.Body.Close()
+
+	var body []byte
+	header := http.Header{}
+	if err != nil {
+		body = statusBody(code, err, resp.Request.Header.Get(requestIDHeader))
+		header.Set("Content-Type", "application/json")
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	resp.StatusCode = code
+	resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	resp.Header = header
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.TransferEncoding = nil
+}
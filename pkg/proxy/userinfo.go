@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// defaultUserInfoCacheTTL is the time a user info response is cached when UserInfoCacheTTL is not set.
+const defaultUserInfoCacheTTL = 5 * time.Minute
+
+// UserInfo is the identity of the logged in user returned by the UserInfo handler.
+type UserInfo struct {
+	Subject           string   `json:"sub,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+}
+
+// userInfoCache caches user info by token hash.
+type userInfoCache struct {
+	mu    sync.Mutex
+	infos map[string]cachedUserInfo
+}
+
+// cachedUserInfo is a user info and its expiry.
+type cachedUserInfo struct {
+	info    *UserInfo
+	expires time.Time
+}
+
+// UserInfo returns the identity of the logged in user, e.g. to show it in a dashboard.
+// When UserInfoEndpoint is set the OpenID Connect userinfo endpoint is called using the
+// session token, otherwise the identity is read from the validated token claims. The
+// response never includes the token, userinfo endpoint responses are cached per token
+// for UserInfoCacheTTL.
+func (s *Server) UserInfo(w http.ResponseWriter, r *http.Request) {
+	// Log request
+	w, done := s.startRequestLog(w, r, "userinfo")
+	defer done()
+
+	token, _ := s.GetRequestToken(r)
+	if token == "" {
+		s.unauthorized(w, r, "no-token", fmt.Errorf("no token received"))
+		return
+	}
+
+	info, err := s.userInfo(r, token)
+	if err != nil {
+		s.unauthorized(w, r, "invalid-token", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(info)
+}
+
+// userInfo returns the user info of a token, user info fetched from the userinfo endpoint
+// is cached, token claims are validated on each request.
+func (s *Server) userInfo(r *http.Request, token string) (*UserInfo, error) {
+	if s.UserInfoEndpoint == "" {
+		claims, err := s.verifyToken(r.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		return claimsUserInfo(claims), nil
+	}
+
+	cache := s.userInfos()
+	key := TokenHash(token)
+	now := time.Now()
+
+	cache.mu.Lock()
+	cached, ok := cache.infos[key]
+	cache.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.info, nil
+	}
+
+	info, err := s.fetchUserInfo(r, token)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := s.UserInfoCacheTTL
+	if ttl <= 0 {
+		ttl = defaultUserInfoCacheTTL
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for k, c := range cache.infos {
+		if now.After(c.expires) {
+			delete(cache.infos, k)
+		}
+	}
+	cache.infos[key] = cachedUserInfo{info: info, expires: now.Add(ttl)}
+
+	return info, nil
+}
+
+// userInfos returns the server user info cache.
+func (s *Server) userInfos() *userInfoCache {
+	s.userInfoOnce.Do(func() {
+		s.userInfoCache = &userInfoCache{infos: map[string]cachedUserInfo{}}
+	})
+
+	return s.userInfoCache
+}
+
+// fetchUserInfo calls the OpenID Connect userinfo endpoint using token.
+func (s *Server) fetchUserInfo(r *http.Request, token string) (*UserInfo, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, s.UserInfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.oauthHTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get user info: %+v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to get user info: %s", resp.Status)
+	}
+
+	claims := jwt.MapClaims{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("fail to parse user info: %+v", err)
+	}
+
+	return claimsUserInfo(claims), nil
+}
+
+// claimsUserInfo returns the identity claims, other claims are dropped.
+func claimsUserInfo(claims jwt.MapClaims) *UserInfo {
+	info := &UserInfo{Groups: claimStrings(claims, "groups")}
+	info.Subject, _ = claims["sub"].(string)
+	info.Name, _ = claims["name"].(string)
+	info.PreferredUsername, _ = claims["preferred_username"].(string)
+	info.Email, _ = claims["email"].(string)
+
+	return info
+}
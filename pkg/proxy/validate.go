@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// promptValues are the OpenID Connect prompt parameter values.
+var promptValues = []string{"none", "login", "consent", "select_account"}
+
+// Validate checks the server configuration invariants, and normalizes the API path
+// to start and end with "/", it should be called before the server starts serving requests.
+func (s *Server) Validate() error {
+	if err := validateServerURL(s.APIServerURL); err != nil {
+		return fmt.Errorf("invalid API server URL: %v", err)
+	}
+
+	if s.APIPath == "" {
+		return fmt.Errorf("missing API path")
+	}
+	s.APIPath = normalizeAPIPath(s.APIPath)
+
+	if isInsecureTransport(s.APITransport) && !s.AllowInsecureUpstream {
+		return fmt.Errorf("API transport skips TLS verification, set AllowInsecureUpstream to allow it")
+	}
+
+	for prefix, route := range s.Routes {
+		if route == nil {
+			return fmt.Errorf("missing route for path (%s)", prefix)
+		}
+		if err := validateServerURL(route.APIServerURL); err != nil {
+			return fmt.Errorf("invalid API server URL for path (%s): %v", prefix, err)
+		}
+		if isInsecureTransport(route.APITransport) && !s.AllowInsecureUpstream {
+			return fmt.Errorf("API transport for path (%s) skips TLS verification, set AllowInsecureUpstream to allow it", prefix)
+		}
+	}
+
+	if _, err := s.upstreamCAs(); err != nil {
+		return fmt.Errorf("invalid upstream CAs: %v", err)
+	}
+
+	if s.InteractiveAuth && !s.oauthConfigured() {
+		return fmt.Errorf("interactive authentication requires an OAuth2 config")
+	}
+
+	for id, p := range s.Providers {
+		if id == "" {
+			return fmt.Errorf("invalid identity provider id (%s)", id)
+		}
+		if p == nil || p.Auth2Config == nil {
+			return fmt.Errorf("identity provider (%s) requires an OAuth2 config", id)
+		}
+	}
+
+	if s.InteractiveAuth && s.LoginEndpoint == "" {
+		return fmt.Errorf("interactive authentication requires a login endpoint")
+	}
+
+	for _, prompt := range strings.Fields(s.Prompt) {
+		if !contains(promptValues, prompt) {
+			return fmt.Errorf("invalid OAuth2 prompt (%s)", prompt)
+		}
+	}
+
+	if s.CORS != nil && s.CORS.AllowCredentials && s.CORS.hasWildcardOrigin() {
+		return fmt.Errorf("CORS credentials can not be allowed for any origin (*), list the allowed origins")
+	}
+
+	if s.BindSessionToClientIP && !s.BindSessionToClient {
+		return fmt.Errorf("client IP session binding requires BindSessionToClient")
+	}
+
+	if (s.VerifyIDToken || s.UseNonce) && !s.oauthConfigured() {
+		return fmt.Errorf("id_token verification requires an OAuth2 config")
+	}
+
+	if s.BearerTokenPassthrough && (s.BearerToken != "" || s.BearerTokenFile != "") {
+		return fmt.Errorf("bearer token and bearer token passthrough are mutually exclusive")
+	}
+
+	if s.BearerTokenFile != "" {
+		if _, err := s.bearerTokenFile().get(); err != nil {
+			return err
+		}
+	}
+
+	if !s.BearerTokenPassthrough && s.BearerToken == "" && s.BearerTokenFile == "" && s.ClientCert == nil {
+		return fmt.Errorf("missing bearer token, set a bearer token, a client certificate or bearer token passthrough")
+	}
+
+	if s.BearerTokenPassthrough && s.ImpersonateUsers {
+		return fmt.Errorf("user impersonation requires a bearer token, and can not be used with bearer token passthrough")
+	}
+
+	if s.BearerTokenPassthrough && s.APITransport == nil {
+		return fmt.Errorf("bearer token passthrough requires an API transport")
+	}
+
+	if s.AccessLogFormat != "" && s.AccessLogFormat != AccessLogCombined && s.AccessLogFormat != AccessLogJSON {
+		return fmt.Errorf("unsupported access log format (%s)", s.AccessLogFormat)
+	}
+
+	if err := compilePathRules(s.PathRules); err != nil {
+		return err
+	}
+
+	if err := validateTokenReview(s.TokenReviewValidation, s.ImpersonateUsers); err != nil {
+		return err
+	}
+
+	if !s.BearerTokenPassthrough && !s.TokenReviewValidation && len(s.JWTTokenKey) == 0 && s.JWTTokenRSAKey == nil && s.JWKSURL == "" {
+		return fmt.Errorf("validating JWT tokens requires a JWT key")
+	}
+
+	if err := validateJWTKey(s.JWTTokenKey); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateTokenReview checks token review validation is used with user impersonation,
+// the operator token authorizes the request for the subject of the reviewed token.
+func validateTokenReview(tokenReview bool, impersonateUsers bool) error {
+	if tokenReview && !impersonateUsers {
+		return fmt.Errorf("token review validation requires user impersonation")
+	}
+	return nil
+}
+
+// validateJWTKey checks the HMAC key is not an RSA public key, e.g. the RSA key file also
+// used as the HMAC key, anyone holding the public key could sign HMAC tokens with it.
+func validateJWTKey(key []byte) error {
+	if len(key) == 0 {
+		return nil
+	}
+
+	if _, err := jwt.ParseRSAPublicKeyFromPEM(key); err == nil {
+		return fmt.Errorf("JWT token key is an RSA public key, it can not be used as an HMAC key")
+	}
+
+	return nil
+}
+
+// validateServerURL checks that a server URL is an absolute http or https URL.
+func validateServerURL(serverURL string) error {
+	if serverURL == "" {
+		return fmt.Errorf("missing URL")
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme (%s)", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+
+	return nil
+}
+
+// isInsecureTransport checks if a transport skips the server certificate verification.
+func isInsecureTransport(transport *http.Transport) bool {
+	return transport != nil && transport.TLSClientConfig != nil && transport.TLSClientConfig.InsecureSkipVerify
+}
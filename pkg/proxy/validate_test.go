@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateAPIServerURL(t *testing.T) {
+	tests := []struct {
+		name         string
+		apiServerURL string
+		wantErr      string
+	}{
+		{name: "https", apiServerURL: "https://kubernetes.default.svc"},
+		{name: "http with port", apiServerURL: "http://127.0.0.1:8080"},
+		{name: "missing", apiServerURL: "", wantErr: "missing URL"},
+		{name: "malformed", apiServerURL: "https://[::1", wantErr: "invalid API server URL"},
+		{name: "unsupported scheme", apiServerURL: "ftp://kubernetes", wantErr: "unsupported scheme (ftp)"},
+		{name: "missing scheme", apiServerURL: "kubernetes.default.svc", wantErr: "unsupported scheme"},
+		{name: "missing host", apiServerURL: "https://", wantErr: "missing host"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{APIServerURL: tt.apiServerURL, APIPath: "/k8s/", BearerToken: "token", JWTTokenKey: testJWTKey}
+
+			err := s.Validate()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Validate() error = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Validate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIProxyInvalidAPIServerURL(t *testing.T) {
+	s := &Server{APIServerURL: "https://[::1", APIPath: "/k8s/"}
+
+	w := httptest.NewRecorder()
+	s.APIProxy().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/k8s/api/v1/pods", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if !strings.Contains(w.Body.String(), "invalid API server URL") {
+		t.Fatalf("body = %s, want an invalid API server URL error", w.Body.String())
+	}
+}
+
+func TestValidateAPIPath(t *testing.T) {
+	tests := []struct {
+		apiPath string
+		want    string
+	}{
+		{apiPath: "/api", want: "/api/"},
+		{apiPath: "/api/", want: "/api/"},
+		{apiPath: "api/", want: "/api/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.apiPath, func(t *testing.T) {
+			s := &Server{APIServerURL: "https://kubernetes.default.svc", APIPath: tt.apiPath, BearerToken: "token", JWTTokenKey: testJWTKey}
+
+			if err := s.Validate(); err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if s.APIPath != tt.want {
+				t.Fatalf("APIPath = %q, want %q", s.APIPath, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// defaultValidationCacheSize is the max number of cached validated tokens when
+// ValidationCacheSize is not set.
+const defaultValidationCacheSize = 10000
+
+// validationCache is a bounded LRU cache of validated token claims by token hash.
+type validationCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// validationEntry is a cached validation result and its expiry.
+type validationEntry struct {
+	key     string
+	claims  jwt.MapClaims
+	expires time.Time
+}
+
+// newValidationCache creates an empty cache holding up to size entries.
+func newValidationCache(size int) *validationCache {
+	return &validationCache{
+		size:    size,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// get returns the cached claims of a token hash, expired entries are removed.
+func (c *validationCache) get(key string, now time.Time) (jwt.MapClaims, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := e.Value.(*validationEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(e)
+	return entry.claims, true
+}
+
+// add caches the claims of a token hash until expires, evicting the least recently
+// used entries when the cache is full.
+func (c *validationCache) add(key string, claims jwt.MapClaims, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value = &validationEntry{key: key, claims: claims, expires: expires}
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&validationEntry{key: key, claims: claims, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*validationEntry).key)
+	}
+}
+
+// remove invalidates the cached claims of a token hash.
+func (c *validationCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+		delete(c.entries, key)
+	}
+}
+
+// validations returns the validation cache of the Server fields configuration, a
+// reloaded configuration has its own cache.
+func (s *Server) validations() *validationCache {
+	s.validationOnce.Do(func() {
+		s.validationCache = s.newValidationCache()
+	})
+
+	return s.validationCache
+}
+
+// newValidationCache returns an empty validation cache of ValidationCacheSize entries.
+func (s *Server) newValidationCache() *validationCache {
+	size := s.ValidationCacheSize
+	if size <= 0 {
+		size = defaultValidationCacheSize
+	}
+
+	return newValidationCache(size)
+}
+
+// validationExpiry returns the time a validation result may be cached, ttl after now,
+// or the token exp claim if it is sooner.
+func validationExpiry(claims jwt.MapClaims, now time.Time, ttl time.Duration) time.Time {
+	expires := now.Add(ttl)
+
+	switch exp := claims["exp"].(type) {
+	case float64:
+		if t := time.Unix(int64(exp), 0); t.Before(expires) {
+			expires = t
+		}
+	case int64:
+		if t := time.Unix(exp, 0); t.Before(expires) {
+			expires = t
+		}
+	}
+
+	return expires
+}
+
+// verifyToken authenticates a request token and returns its claims, using a TokenReview
+// when TokenReviewValidation is set, or validateToken. Validated tokens are cached for
+// ValidationCacheTTL, reviewed tokens for TokenReviewCacheTTL, and never after the token
+// exp claim. Cached tokens are checked against the revocation list on each request.
+func (s *Server) verifyToken(ctx context.Context, token string) (jwt.MapClaims, error) {
+	ttl := s.ValidationCacheTTL
+	if s.TokenReviewValidation {
+		ttl = s.TokenReviewCacheTTL
+		if ttl <= 0 {
+			ttl = defaultTokenReviewCacheTTL
+		}
+	}
+	if ttl <= 0 {
+		return s.validateToken(token)
+	}
+
+	// The cache of the configuration the token is validated with, a Reload while
+	// validating replaces the cache, and the result is not cached for the new keys
+	cache := s.current().validations
+	key := TokenHash(token)
+	now := time.Now()
+
+	if claims, ok := cache.get(key, now); ok {
+		if err := s.checkRevoked(token, claims); err != nil {
+			cache.remove(key)
+			return nil, err
+		}
+		return claims, nil
+	}
+
+	var claims jwt.MapClaims
+	var err error
+	if s.TokenReviewValidation {
+		if claims, err = s.reviewToken(ctx, token); err == nil {
+			err = s.checkRevoked(token, claims)
+		}
+	} else {
+		claims, err = s.validateToken(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cache.add(key, claims, validationExpiry(claims, now, ttl))
+	return claims, nil
+}
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestValidationCache(t *testing.T) {
+	now := time.Now()
+	claims := jwt.MapClaims{"sub": "user"}
+
+	tests := []struct {
+		name    string
+		expires time.Time
+		at      time.Time
+		want    bool
+	}{
+		{name: "fresh", expires: now.Add(time.Minute), at: now, want: true},
+		{name: "before expiry", expires: now.Add(time.Minute), at: now.Add(59 * time.Second), want: true},
+		{name: "at expiry", expires: now.Add(time.Minute), at: now.Add(time.Minute), want: false},
+		{name: "after expiry", expires: now.Add(time.Minute), at: now.Add(2 * time.Minute), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newValidationCache(10)
+			c.add("key", claims, tt.expires)
+
+			if _, ok := c.get("key", tt.at); ok != tt.want {
+				t.Fatalf("get() ok = %v, want %v", ok, tt.want)
+			}
+			if !tt.want && c.order.Len() != 0 {
+				t.Fatalf("expired entry was not removed, %d entries", c.order.Len())
+			}
+		})
+	}
+}
+
+func TestValidationCacheEviction(t *testing.T) {
+	now := time.Now()
+	c := newValidationCache(2)
+
+	c.add("a", jwt.MapClaims{}, now.Add(time.Minute))
+	c.add("b", jwt.MapClaims{}, now.Add(time.Minute))
+	c.get("a", now)
+	c.add("c", jwt.MapClaims{}, now.Add(time.Minute))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(key, now); ok != want {
+			t.Errorf("get(%s) ok = %v, want %v", key, ok, want)
+		}
+	}
+}
+
+func TestValidationExpiry(t *testing.T) {
+	now := time.Unix(1000000, 0)
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		ttl    time.Duration
+		want   time.Time
+	}{
+		{name: "no exp claim", claims: jwt.MapClaims{}, ttl: time.Minute, want: now.Add(time.Minute)},
+		{name: "exp after ttl", claims: jwt.MapClaims{"exp": float64(now.Add(time.Hour).Unix())}, ttl: time.Minute, want: now.Add(time.Minute)},
+		{name: "exp before ttl", claims: jwt.MapClaims{"exp": float64(now.Add(10 * time.Second).Unix())}, ttl: time.Minute, want: now.Add(10 * time.Second)},
+		{name: "int64 exp before ttl", claims: jwt.MapClaims{"exp": now.Add(10 * time.Second).Unix()}, ttl: time.Minute, want: now.Add(10 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validationExpiry(tt.claims, now, tt.ttl); !got.Equal(tt.want) {
+				t.Fatalf("validationExpiry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyTokenCache(t *testing.T) {
+	token := signHS256(t, testJWTKey, jwt.MapClaims{"sub": "user"})
+
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		wantCached bool
+	}{
+		{name: "cache disabled", ttl: 0, wantCached: false},
+		{name: "cache enabled", ttl: time.Minute, wantCached: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{JWTTokenKey: testJWTKey, ValidationCacheTTL: tt.ttl}
+			if _, err := s.verifyToken(context.Background(), token); err != nil {
+				t.Fatalf("verifyToken() error = %v", err)
+			}
+
+			// Cached validations do not verify the token signature again
+			s.JWTTokenKey = []byte("other-key")
+			_, err := s.verifyToken(context.Background(), token)
+			if cached := err == nil; cached != tt.wantCached {
+				t.Fatalf("verifyToken() error = %v, want cached %v", err, tt.wantCached)
+			}
+
+			// Revoked tokens are rejected, even when cached
+			if err := s.Revoke(TokenHash(token)); err != nil {
+				t.Fatalf("Revoke() error = %v", err)
+			}
+			if _, err := s.verifyToken(context.Background(), token); err == nil {
+				t.Fatalf("verifyToken() error = nil, want an error for a revoked token")
+			}
+		})
+	}
+}